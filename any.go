@@ -0,0 +1,209 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+
+	gogotypes "github.com/gogo/protobuf/types"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Equal returns true if a and b have the same type url and value, regardless
+// of which implementation of Any they are. Two nil Any values are equal.
+func Equal(a, b Any) bool {
+	if isNil(a) || isNil(b) {
+		return isNil(a) && isNil(b)
+	}
+	return a.GetTypeUrl() == b.GetTypeUrl() && bytes.Equal(a.GetValue(), b.GetValue())
+}
+
+// New returns an Any with the given type url and value, without depending on
+// the protocol buffer library backing it. The value is not copied.
+func New(typeURL string, value []byte) Any {
+	return &anyType{
+		typeURL: typeURL,
+		value:   value,
+	}
+}
+
+// cachedAny is an Any holding its decoded value.
+type cachedAny struct {
+	Any
+	decoded interface{}
+}
+
+// Cached returns an Any with the type url and value of any for which
+// UnmarshalAny returns decoded directly, without decoding the value, as long
+// as decoded is a pointer to the type the type url resolves to. This avoids
+// decoding an Any repeatedly when it is passed through several stages in
+// process; the stages share decoded, so it must not be modified. The checksum
+// and size of the value are still verified, and Validate decodes the value
+// regardless. Nil and typed nil values are returned unchanged.
+func Cached(any Any, decoded interface{}) Any {
+	if isNil(any) {
+		return any
+	}
+	return &cachedAny{Any: any, decoded: decoded}
+}
+
+// ToProto converts any into a google.golang.org/protobuf Any, returning it
+// unchanged if it already is one. The value bytes are shared with any. Nil and
+// typed nil values return nil.
+func ToProto(any Any) *anypb.Any {
+	if isNil(any) {
+		return nil
+	}
+	if pb, ok := any.(*anypb.Any); ok {
+		return pb
+	}
+	return &anypb.Any{
+		TypeUrl: any.GetTypeUrl(),
+		Value:   any.GetValue(),
+	}
+}
+
+// ToGogo converts any into a github.com/gogo/protobuf Any, returning it
+// unchanged if it already is one. The value bytes are shared with any. Nil and
+// typed nil values return nil.
+func ToGogo(any Any) *gogotypes.Any {
+	if isNil(any) {
+		return nil
+	}
+	if pb, ok := any.(*gogotypes.Any); ok {
+		return pb
+	}
+	return &gogotypes.Any{
+		TypeUrl: any.GetTypeUrl(),
+		Value:   any.GetValue(),
+	}
+}
+
+// AsProto returns the Any as a google.golang.org/protobuf Any for APIs which
+// require one. Values returned by MarshalAny provide it, and callers may check
+// for it with an interface{ AsProto() *anypb.Any } assertion. See ToProto.
+func (a *anyType) AsProto() *anypb.Any {
+	return ToProto(a)
+}
+
+// AsGogo returns the Any as a github.com/gogo/protobuf Any for APIs which
+// require one. See ToGogo.
+func (a *anyType) AsGogo() *gogotypes.Any {
+	return ToGogo(a)
+}
+
+// anyURL is the type url of an Any nested in the value of another Any.
+const anyURL = "google.protobuf.Any"
+
+// Wrap returns an Any of type google.protobuf.Any whose value is any, encoded
+// as a protocol buffer message. It is the inverse of Unwrap. Unlike
+// MarshalAny, which returns an Any verbatim, Wrap always nests it.
+func Wrap(any Any) (Any, error) {
+	if isNil(any) {
+		return nil, fmt.Errorf("cannot wrap a nil any")
+	}
+	value, err := proto.Marshal(ToProto(any))
+	if err != nil {
+		return nil, err
+	}
+	return &anyType{
+		typeURL: anyURL,
+		value:   value,
+	}, nil
+}
+
+// Unwrap returns the Any nested in the value of any, which must be of type
+// google.protobuf.Any, with or without a url prefix.
+func Unwrap(any Any) (Any, error) {
+	if isNil(any) {
+		return nil, fmt.Errorf("cannot unwrap a nil any")
+	}
+	if urlName(any.GetTypeUrl()) != anyURL {
+		return nil, fmt.Errorf("type %q is not %s", any.GetTypeUrl(), anyURL)
+	}
+	inner := &anypb.Any{}
+	if err := proto.Unmarshal(any.GetValue(), inner); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal type %q: %w", anyURL, err)
+	}
+	return inner, nil
+}
+
+// Clone returns a copy of any with a freshly allocated value, so that it is
+// unaffected by modifications of the value of any. The copy has the same
+// concrete type as any when it is one of the Any implementations known to
+// this package. Nil and typed nil values return nil.
+func Clone(any Any) Any {
+	if isNil(any) {
+		return nil
+	}
+	value := ValueCopy(any)
+	switch any.(type) {
+	case *anypb.Any:
+		return &anypb.Any{TypeUrl: any.GetTypeUrl(), Value: value}
+	case *gogotypes.Any:
+		return &gogotypes.Any{TypeUrl: any.GetTypeUrl(), Value: value}
+	default:
+		return &anyType{typeURL: any.GetTypeUrl(), value: value}
+	}
+}
+
+// ValueCopy returns a freshly allocated copy of the value of any, which may be
+// retained or modified without affecting any. Values returned by GetValue may
+// be shared with the marshaled input or other Any values. Nil and typed nil
+// values, and nil values of any, return nil.
+func ValueCopy(any Any) []byte {
+	if isNil(any) {
+		return nil
+	}
+	v := any.GetValue()
+	if v == nil {
+		return nil
+	}
+	return append([]byte{}, v...)
+}
+
+// RewriteURL returns a copy of any with its type url replaced by the result of
+// fn, leaving the value untouched and shared with any. This allows type urls to
+// be normalized without decoding the value. Nil and typed nil values return
+// nil.
+func RewriteURL(any Any, fn func(string) string) Any {
+	if isNil(any) {
+		return nil
+	}
+	return &anyType{
+		typeURL: fn(any.GetTypeUrl()),
+		value:   any.GetValue(),
+	}
+}
+
+// IsEmpty returns true if any carries no value: it is nil, a typed nil
+// pointer, or has an empty type url and an empty value.
+func IsEmpty(any Any) bool {
+	return isNil(any) || (any.GetTypeUrl() == "" && len(any.GetValue()) == 0)
+}
+
+// isNil returns true if any is nil or a typed nil pointer.
+func isNil(any Any) bool {
+	if any == nil {
+		return true
+	}
+	v := reflect.ValueOf(any)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
@@ -0,0 +1,271 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	gogotypes "github.com/gogo/protobuf/types"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+func TestEqual(t *testing.T) {
+	var (
+		nilpb   *anypb.Any
+		nilgogo *gogotypes.Any
+	)
+	for _, testcase := range []struct {
+		name     string
+		a, b     Any
+		expected bool
+	}{
+		{
+			name:     "Nil",
+			expected: true,
+		},
+		{
+			name:     "TypedNil",
+			a:        nilpb,
+			b:        nilgogo,
+			expected: true,
+		},
+		{
+			name: "NilAndEmpty",
+			a:    nilpb,
+			b:    &anypb.Any{},
+		},
+		{
+			name:     "ProtoAndGogo",
+			a:        &anypb.Any{TypeUrl: "test", Value: []byte("value")},
+			b:        &gogotypes.Any{TypeUrl: "test", Value: []byte("value")},
+			expected: true,
+		},
+		{
+			name:     "Internal",
+			a:        &anyType{typeURL: "test", value: []byte("value")},
+			b:        &anypb.Any{TypeUrl: "test", Value: []byte("value")},
+			expected: true,
+		},
+		{
+			name: "DifferentURL",
+			a:    &anypb.Any{TypeUrl: "test", Value: []byte("value")},
+			b:    &gogotypes.Any{TypeUrl: "test2", Value: []byte("value")},
+		},
+		{
+			name: "DifferentValue",
+			a:    &anypb.Any{TypeUrl: "test", Value: []byte("value")},
+			b:    &gogotypes.Any{TypeUrl: "test", Value: []byte("value2")},
+		},
+	} {
+		t.Run(testcase.name, func(t *testing.T) {
+			if actual := Equal(testcase.a, testcase.b); actual != testcase.expected {
+				t.Fatalf("expected %v, got %v", testcase.expected, actual)
+			}
+			if actual := Equal(testcase.b, testcase.a); actual != testcase.expected {
+				t.Fatalf("expected %v for reversed arguments, got %v", testcase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	a := New("test", []byte("value"))
+	if a.GetTypeUrl() != "test" || string(a.GetValue()) != "value" {
+		t.Fatalf("unexpected any %q %q", a.GetTypeUrl(), a.GetValue())
+	}
+	if !Equal(a, &anypb.Any{TypeUrl: "test", Value: []byte("value")}) {
+		t.Fatal("expected New to equal the equivalent proto any")
+	}
+}
+
+func TestWrapUnwrap(t *testing.T) {
+	inner := &anyType{typeURL: "test", value: []byte("value")}
+	outer, err := Wrap(inner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outer.GetTypeUrl() != "google.protobuf.Any" {
+		t.Fatalf("unexpected url %q", outer.GetTypeUrl())
+	}
+	for _, a := range []Any{outer, &anypb.Any{TypeUrl: "type.googleapis.com/google.protobuf.Any", Value: outer.GetValue()}} {
+		unwrapped, err := Unwrap(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !Equal(unwrapped, inner) {
+			t.Fatalf("expected %v, got %v", inner, unwrapped)
+		}
+	}
+
+	if _, err := Unwrap(inner); err == nil {
+		t.Fatal("expected an error unwrapping an Any which is not nested")
+	}
+	if _, err := Unwrap(&anypb.Any{TypeUrl: "google.protobuf.Any", Value: []byte{0xff}}); err == nil {
+		t.Fatal("expected an error unwrapping a malformed value")
+	}
+	if _, err := Wrap(nil); err == nil {
+		t.Fatal("expected an error wrapping nil")
+	}
+	for _, any := range []Any{nil, (*anypb.Any)(nil)} {
+		if _, err := Unwrap(any); err == nil {
+			t.Fatalf("expected an error unwrapping %#v", any)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	var (
+		a       = &anyType{typeURL: "test", value: []byte("value")}
+		nilpb   *anypb.Any
+		nilgogo *gogotypes.Any
+	)
+
+	pb := ToProto(a)
+	if pb.TypeUrl != "test" || string(pb.Value) != "value" {
+		t.Fatalf("unexpected proto any %v", pb)
+	}
+	gogo := ToGogo(pb)
+	if gogo.TypeUrl != "test" || string(gogo.Value) != "value" {
+		t.Fatalf("unexpected gogo any %v", gogo)
+	}
+	if ToProto(pb) != pb {
+		t.Fatal("expected proto any to be returned unchanged")
+	}
+	if ToGogo(gogo) != gogo {
+		t.Fatal("expected gogo any to be returned unchanged")
+	}
+
+	for _, any := range []Any{nil, nilpb, nilgogo, (*anyType)(nil)} {
+		if pb := ToProto(any); pb != nil {
+			t.Fatalf("expected nil for %T, got %v", any, pb)
+		}
+		if gogo := ToGogo(any); gogo != nil {
+			t.Fatalf("expected nil for %T, got %v", any, gogo)
+		}
+	}
+}
+
+func TestAsProtoAsGogo(t *testing.T) {
+	var a Any = &anyType{typeURL: "test", value: []byte("value")}
+
+	pb := a.(interface{ AsProto() *anypb.Any }).AsProto()
+	if pb.TypeUrl != "test" || string(pb.Value) != "value" {
+		t.Fatalf("unexpected proto any %v", pb)
+	}
+	gogo := a.(interface{ AsGogo() *gogotypes.Any }).AsGogo()
+	if gogo.TypeUrl != "test" || string(gogo.Value) != "value" {
+		t.Fatalf("unexpected gogo any %v", gogo)
+	}
+
+	var nilany *anyType
+	if nilany.AsProto() != nil || nilany.AsGogo() != nil {
+		t.Fatal("expected nil for a nil any")
+	}
+}
+
+func TestClone(t *testing.T) {
+	for _, any := range []Any{
+		&anyType{typeURL: "test", value: []byte("value")},
+		&anypb.Any{TypeUrl: "test", Value: []byte("value")},
+		&gogotypes.Any{TypeUrl: "test", Value: []byte("value")},
+	} {
+		c := Clone(any)
+		if reflect.TypeOf(c) != reflect.TypeOf(any) {
+			t.Fatalf("expected clone of type %T, got %T", any, c)
+		}
+		if !Equal(c, any) {
+			t.Fatalf("expected clone to equal original: %v != %v", c, any)
+		}
+		c.GetValue()[0] ^= 0xff
+		if Equal(c, any) {
+			t.Fatalf("modifying the clone of %T modified the original", any)
+		}
+	}
+
+	var (
+		nilpb   *anypb.Any
+		nilgogo *gogotypes.Any
+	)
+	for _, any := range []Any{nil, nilpb, nilgogo} {
+		if c := Clone(any); c != nil {
+			t.Fatalf("expected nil for %T, got %v", any, c)
+		}
+	}
+}
+
+func TestRewriteURL(t *testing.T) {
+	any := &anypb.Any{TypeUrl: "type.googleapis.com/pkg.Type", Value: []byte("value")}
+
+	rewritten := RewriteURL(any, func(url string) string {
+		return strings.TrimPrefix(url, "type.googleapis.com/")
+	})
+	if rewritten.GetTypeUrl() != "pkg.Type" {
+		t.Fatalf("expected %q but received %q", "pkg.Type", rewritten.GetTypeUrl())
+	}
+	if string(rewritten.GetValue()) != "value" {
+		t.Fatalf("expected value to be untouched, got %q", rewritten.GetValue())
+	}
+	if any.TypeUrl != "type.googleapis.com/pkg.Type" {
+		t.Fatal("rewriting modified the original any")
+	}
+
+	var nilpb *anypb.Any
+	if rewritten := RewriteURL(nilpb, strings.ToUpper); rewritten != nil {
+		t.Fatalf("expected nil, got %v", rewritten)
+	}
+}
+
+func TestIsEmpty(t *testing.T) {
+	var nilAny *anypb.Any
+	for _, testcase := range []struct {
+		any      Any
+		expected bool
+	}{
+		{any: nil, expected: true},
+		{any: nilAny, expected: true},
+		{any: (*gogotypes.Any)(nil), expected: true},
+		{any: &anypb.Any{}, expected: true},
+		{any: &anypb.Any{Value: []byte{}}, expected: true},
+		{any: New("", nil), expected: true},
+		{any: &anypb.Any{TypeUrl: "test"}, expected: false},
+		{any: &anypb.Any{Value: []byte("{}")}, expected: false},
+	} {
+		if empty := IsEmpty(testcase.any); empty != testcase.expected {
+			t.Fatalf("expected %v for %#v, got %v", testcase.expected, testcase.any, empty)
+		}
+	}
+}
+
+func TestValueCopy(t *testing.T) {
+	any := &anypb.Any{TypeUrl: "test", Value: []byte("value")}
+	value := ValueCopy(any)
+	if string(value) != "value" {
+		t.Fatalf("expected a copy of the value, got %q", value)
+	}
+	value[0] = 'V'
+	if string(any.Value) != "value" {
+		t.Fatalf("modifying the copy should not modify the any, got %q", any.Value)
+	}
+	if v := ValueCopy(&anypb.Any{Value: []byte{}}); v == nil || len(v) != 0 {
+		t.Fatalf("expected an empty value, got %#v", v)
+	}
+	if v := ValueCopy((*anypb.Any)(nil)); v != nil {
+		t.Fatalf("expected nil, got %v", v)
+	}
+}
@@ -0,0 +1,74 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+)
+
+// ErrChecksumMismatch is returned when unmarshaling a value whose checksum
+// does not match the one recorded by MarshalAnyChecksummed.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+const checksumParam = "crc32c"
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// MarshalAnyChecksummed marshals the value v into an any in the same way as
+// MarshalAny, recording a CRC-32C checksum of the value in the query string of
+// the type url, such as "types.example.com/Foo?crc32c=1a2b3c4d". Since the Any
+// interface only carries a type url and value, the checksum has nowhere else
+// to go. An Any is not marshaled again, but its type url gains a checksum of
+// its value.
+//
+// UnmarshalAny verifies the checksum of any value carrying one, returning an
+// error wrapping ErrChecksumMismatch if the value was corrupted.
+func MarshalAnyChecksummed(v interface{}) (Any, error) {
+	return DefaultRegistry.MarshalAnyChecksummed(v)
+}
+
+// MarshalAnyChecksummed marshals the value v using the type urls of the
+// registry, recording a checksum of the value. See MarshalAnyChecksummed.
+func (r *Registry) MarshalAnyChecksummed(v interface{}) (Any, error) {
+	any, err := r.MarshalAny(v)
+	if err != nil || isNil(any) {
+		return any, err
+	}
+	return &anyType{
+		typeURL: withParam(any.GetTypeUrl(), checksumParam, checksum(any.GetValue())),
+		value:   any.GetValue(),
+	}, nil
+}
+
+func checksum(value []byte) string {
+	return fmt.Sprintf("%08x", crc32.Checksum(value, castagnoli))
+}
+
+// verifyChecksum checks the value against the checksum recorded in its type
+// url, if any.
+func verifyChecksum(e encodedURL, value []byte) error {
+	expected := e.params.Get(checksumParam)
+	if expected == "" {
+		return nil
+	}
+	if actual := checksum(value); actual != expected {
+		return fmt.Errorf("type %q has checksum %s, expected %s: %w", e.url, actual, expected, ErrChecksumMismatch)
+	}
+	return nil
+}
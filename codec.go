@@ -0,0 +1,185 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+
+	gogoproto "github.com/gogo/protobuf/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// Codec marshals and unmarshals registered types which are not protocol
+// buffer messages.
+type Codec interface {
+	// Name returns the name of the codec. Any values encoded by a codec
+	// other than JSONCodec record the name as a "+name" suffix on their type
+	// url, so that they are decoded by the same codec.
+	Name() string
+
+	// Marshal returns the encoding of v.
+	Marshal(v interface{}) ([]byte, error)
+
+	// Unmarshal decodes data into the value pointed to by v.
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// JSONCodec is the default Codec, encoding values with encoding/json.
+type JSONCodec struct{}
+
+// Name returns "json".
+func (JSONCodec) Name() string {
+	return "json"
+}
+
+// Marshal returns the JSON encoding of v.
+func (JSONCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// Unmarshal decodes the JSON encoded data into v.
+func (JSONCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+var (
+	codecMu      sync.RWMutex
+	defaultCodec Codec = JSONCodec{}
+	codecs             = map[string]Codec{
+		JSONCodec{}.Name(): JSONCodec{},
+	}
+)
+
+// RegisterCodec makes the codec available for unmarshaling Any values which
+// were encoded by it, without changing the codec used by MarshalAny.
+func RegisterCodec(c Codec) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	codecs[c.Name()] = c
+}
+
+// SetDefaultCodec sets the codec used by MarshalAny for registered types
+// which are not protocol buffer messages. The codec also becomes available
+// for unmarshaling Any values which were encoded by it.
+//
+// Any values encoded with JSON, the default codec, carry no codec suffix on
+// their type url, so they continue to decode after the default changes.
+func SetDefaultCodec(c Codec) {
+	codecMu.Lock()
+	defer codecMu.Unlock()
+	codecs[c.Name()] = c
+	defaultCodec = c
+}
+
+func getDefaultCodec() Codec {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	return defaultCodec
+}
+
+// codecURL returns the type url recording that a value of the type
+// registered as url was encoded with c.
+func codecURL(url string, c Codec) string {
+	if name := c.Name(); name != (JSONCodec{}).Name() {
+		return withSuffix(url, "+"+name)
+	}
+	return url
+}
+
+// splitCodec splits the codec suffix from a type url, returning the type url
+// the type was registered with and the codec the value was encoded with.
+func splitCodec(typeURL string) (string, Codec) {
+	codecMu.RLock()
+	defer codecMu.RUnlock()
+	if i := strings.LastIndex(typeURL, "+"); i >= 0 {
+		if c, ok := codecs[typeURL[i+1:]]; ok {
+			return typeURL[:i], c
+		}
+	}
+	return typeURL, codecs[JSONCodec{}.Name()]
+}
+
+// Encodings reported by Encoding, in addition to the names of codecs.
+const (
+	EncodingProtobuf = "protobuf"
+	EncodingUnknown  = "unknown"
+)
+
+// Encoding reports how the value of any is encoded without decoding it. It
+// returns EncodingProtobuf for protocol buffer messages and the codec name,
+// such as "json", for other registered types. If the type url cannot be
+// resolved, or any is nil, EncodingUnknown is returned along with an error.
+func Encoding(any Any) (string, error) {
+	return DefaultRegistry.Encoding(any)
+}
+
+// Encoding reports how the value of any is encoded, resolving its type url
+// with the registry. See Encoding.
+func (r *Registry) Encoding(any Any) (string, error) {
+	if isNil(any) {
+		return EncodingUnknown, errors.New("cannot report the encoding of a nil any")
+	}
+	e := parseTypeURL(any.GetTypeUrl())
+	t, err := r.getTypeByUrl(e.url)
+	if err != nil {
+		return EncodingUnknown, err
+	}
+	switch reflect.New(t.t).Interface().(type) {
+	case proto.Message, gogoproto.Message:
+		if !e.json {
+			return EncodingProtobuf, nil
+		}
+	}
+	return e.codec.Name(), nil
+}
+
+// contentTypes maps encodings reported by Encoding to media types.
+var contentTypes = map[string]string{
+	EncodingProtobuf: "application/x-protobuf",
+	"json":           "application/json",
+	"msgpack":        "application/msgpack",
+}
+
+// ContentType returns the media type of the value of any, suitable for a
+// Content-Type header, such as "application/x-protobuf" or
+// "application/json". It is derived from the encoding reported by Encoding;
+// nil Anys and values whose encoding is unknown or has no known media type are
+// reported as "application/octet-stream". Compression is not reflected in the
+// result.
+func ContentType(any Any) string {
+	return DefaultRegistry.ContentType(any)
+}
+
+// ContentType returns the media type of the value of any, resolving its type
+// url with the registry. See ContentType.
+func (r *Registry) ContentType(any Any) string {
+	if isNil(any) {
+		return "application/octet-stream"
+	}
+	encoding, err := r.Encoding(any)
+	if err != nil {
+		return "application/octet-stream"
+	}
+	if ct, ok := contentTypes[encoding]; ok {
+		return ct
+	}
+	return "application/octet-stream"
+}
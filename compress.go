@@ -0,0 +1,82 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+)
+
+// MarshalAnyCompressed marshals the value v into an any in the same way as
+// MarshalAny, compressing the value with gzip when it is larger than threshold
+// bytes. Compressed values carry a "+gzip" suffix on their type url, which
+// UnmarshalAny detects to decompress the value before decoding it.
+//
+// An Any is returned unchanged, since its value may already be compressed or
+// carry a checksum which compression would invalidate.
+func MarshalAnyCompressed(v interface{}, threshold int) (Any, error) {
+	return DefaultRegistry.MarshalAnyCompressed(v, threshold)
+}
+
+// MarshalAnyCompressed marshals the value v using the type urls of the
+// registry, compressing large values. See MarshalAnyCompressed.
+func (r *Registry) MarshalAnyCompressed(v interface{}, threshold int) (Any, error) {
+	if any, ok := v.(Any); ok {
+		return any, nil
+	}
+	any, err := r.MarshalAny(v)
+	if err != nil || isNil(any) {
+		return any, err
+	}
+	if len(any.GetValue()) <= threshold {
+		return any, nil
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(any.GetValue()); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return &anyType{
+		typeURL: withSuffix(any.GetTypeUrl(), gzipSuffix),
+		value:   buf.Bytes(),
+	}, nil
+}
+
+// decompress decompresses value, failing with ErrTooLarge if the result
+// exceeds limit bytes. A limit of zero or less is unlimited.
+func decompress(value []byte, limit int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(value))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	b, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > limit {
+		return nil, ErrTooLarge
+	}
+	return b, nil
+}
@@ -0,0 +1,96 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import "context"
+
+// MarshalAnyCtx marshals the value v into an any in the same way as
+// MarshalAny, returning early with the context error if ctx is done before or
+// after marshaling.
+func MarshalAnyCtx(ctx context.Context, v interface{}) (Any, error) {
+	return DefaultRegistry.MarshalAnyCtx(ctx, v)
+}
+
+// MarshalAnyCtx marshals the value v into an any using the type urls of the
+// registry. See MarshalAnyCtx.
+func (r *Registry) MarshalAnyCtx(ctx context.Context, v interface{}) (Any, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	any, err := r.MarshalAny(v)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return any, nil
+}
+
+// UnmarshalAnyCtx unmarshals the any type into a concrete type in the same
+// way as UnmarshalAny, returning early with the context error if ctx is done
+// before or after unmarshaling.
+func UnmarshalAnyCtx(ctx context.Context, any Any) (interface{}, error) {
+	return DefaultRegistry.UnmarshalAnyCtx(ctx, any)
+}
+
+// UnmarshalAnyCtx unmarshals the any type into a concrete type resolved by
+// the registry. See UnmarshalAnyCtx.
+func (r *Registry) UnmarshalAnyCtx(ctx context.Context, any Any) (interface{}, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	v, err := r.UnmarshalAny(any)
+	if err != nil {
+		return nil, err
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+type registryKey struct{}
+
+// WithRegistry returns a copy of ctx carrying the registry r, which
+// MarshalAnyContext and UnmarshalAnyContext use instead of DefaultRegistry.
+// This allows a process to serve several type namespaces, such as one per
+// tenant, with the registry chosen per request.
+func WithRegistry(ctx context.Context, r *Registry) context.Context {
+	return context.WithValue(ctx, registryKey{}, r)
+}
+
+// FromContext returns the registry carried by ctx, or DefaultRegistry if ctx
+// does not carry one.
+func FromContext(ctx context.Context) *Registry {
+	if r, ok := ctx.Value(registryKey{}).(*Registry); ok && r != nil {
+		return r
+	}
+	return DefaultRegistry
+}
+
+// MarshalAnyContext marshals the value v into an any in the same way as
+// MarshalAnyCtx, using the registry returned by FromContext.
+func MarshalAnyContext(ctx context.Context, v interface{}) (Any, error) {
+	return FromContext(ctx).MarshalAnyCtx(ctx, v)
+}
+
+// UnmarshalAnyContext unmarshals the any type into a concrete type in the
+// same way as UnmarshalAnyCtx, using the registry returned by FromContext.
+func UnmarshalAnyContext(ctx context.Context, any Any) (interface{}, error) {
+	return FromContext(ctx).UnmarshalAnyCtx(ctx, any)
+}
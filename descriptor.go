@@ -0,0 +1,62 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"fmt"
+	"reflect"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// RegisterFileDescriptor registers every message defined in fd, including
+// nested messages, using its full name as the URL. The messages must be
+// generated Go types linked into the binary. Messages already registered
+// under their full name are left untouched. An error is returned if a message
+// is already registered under another URL, or its full name is registered to
+// another type; messages before it remain registered.
+func RegisterFileDescriptor(fd protoreflect.FileDescriptor) error {
+	return DefaultRegistry.RegisterFileDescriptor(fd)
+}
+
+// RegisterFileDescriptor registers every message defined in fd with the
+// registry. See RegisterFileDescriptor.
+func (r *Registry) RegisterFileDescriptor(fd protoreflect.FileDescriptor) error {
+	return r.registerMessages(fd.Messages())
+}
+
+func (r *Registry) registerMessages(mds protoreflect.MessageDescriptors) error {
+	for i := 0; i < mds.Len(); i++ {
+		md := mds.Get(i)
+		// map entries are synthesized and have no Go type.
+		if md.IsMapEntry() {
+			continue
+		}
+		mt, err := protoregistry.GlobalTypes.FindMessageByName(md.FullName())
+		if err != nil {
+			return fmt.Errorf("message %s: %w", md.FullName(), ErrNotFound)
+		}
+		if err := r.tryRegister(reflect.TypeOf(mt.Zero().Interface()).Elem(), string(md.FullName())); err != nil {
+			return err
+		}
+		if err := r.registerMessages(md.Messages()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// ErrorURL is the type url of errors marshaled by MarshalError whose type is
+// not registered. Their value is a JSON object holding the error message, such
+// as {"message":"file not found"}.
+const ErrorURL = "typeurl.Error"
+
+type errorValue struct {
+	Message string `json:"message"`
+}
+
+// MarshalError marshals err into an any. If the concrete type of err is
+// registered, it is marshaled as MarshalAny would, preserving the type.
+// Otherwise only the message of err is kept, under ErrorURL. A nil error
+// marshals to a nil Any.
+func MarshalError(err error) (Any, error) {
+	return DefaultRegistry.MarshalError(err)
+}
+
+// MarshalError marshals err into an any using the type urls of the registry.
+// See MarshalError.
+func (r *Registry) MarshalError(err error) (Any, error) {
+	if err == nil {
+		return nil, nil
+	}
+	// only pointers can be registered, so errors of other kinds, such as
+	// syscall.Errno, are never registered.
+	if reflect.TypeOf(err).Kind() != reflect.Ptr {
+		return marshalErrorMessage(err)
+	}
+	if _, ok := r.LookupTypeURL(err); ok {
+		return r.MarshalAny(err)
+	}
+	return marshalErrorMessage(err)
+}
+
+// marshalErrorMessage marshals the message of err under ErrorURL.
+func marshalErrorMessage(err error) (Any, error) {
+	value, merr := json.Marshal(errorValue{Message: err.Error()})
+	if merr != nil {
+		return nil, merr
+	}
+	return &anyType{
+		typeURL: ErrorURL,
+		value:   value,
+	}, nil
+}
+
+// UnmarshalError reconstructs an error marshaled by MarshalError. Errors of
+// registered types are unmarshaled to their type, while errors marshaled under
+// ErrorURL are returned as an error with the same message, created by
+// errors.New. A nil Any unmarshals to a nil error.
+//
+// If the Any cannot be unmarshaled, or does not hold an error, the error
+// returned describes the failure instead, so that it is never lost.
+func UnmarshalError(any Any) error {
+	return DefaultRegistry.UnmarshalError(any)
+}
+
+// UnmarshalError reconstructs an error, resolving its type url with the
+// registry. See UnmarshalError.
+func (r *Registry) UnmarshalError(any Any) error {
+	if isNil(any) {
+		return nil
+	}
+	if any.GetTypeUrl() == ErrorURL {
+		var ev errorValue
+		if err := json.Unmarshal(any.GetValue(), &ev); err != nil {
+			return fmt.Errorf("failed to unmarshal type %q: %w", ErrorURL, err)
+		}
+		return errors.New(ev.Message)
+	}
+	v, err := r.UnmarshalAny(any)
+	if err != nil {
+		return fmt.Errorf("failed to unmarshal error: %w", err)
+	}
+	e, ok := v.(error)
+	if !ok {
+		return fmt.Errorf("type %q is not an error", any.GetTypeUrl())
+	}
+	return e
+}
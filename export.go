@@ -0,0 +1,127 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	gogoproto "github.com/gogo/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+)
+
+// RegistryEntry is the serializable form of a url registered for a protocol
+// buffer message, as returned by ExportRegistry.
+type RegistryEntry struct {
+	URL           string `json:"url"`
+	ProtoFullName string `json:"protoFullName"`
+}
+
+// ExportRegistry returns the urls registered for protocol buffer messages,
+// including aliases and versioned urls, along with the full names of the
+// messages, so that the registrations can be persisted or sent to another
+// process and restored with ImportRegistry. The first url of each type is
+// listed before its aliases.
+//
+// Types which are not protocol buffer messages cannot be resolved from a
+// name and are left out.
+func ExportRegistry() []RegistryEntry {
+	return DefaultRegistry.ExportRegistry()
+}
+
+// ExportRegistry returns the urls registered for protocol buffer messages
+// with the registry. See ExportRegistry.
+func (r *Registry) ExportRegistry() []RegistryEntry {
+	s := r.load()
+	types := make([]reflect.Type, 0, len(s.types))
+	for t := range s.types {
+		if isMessageType(t) {
+			types = append(types, t)
+		}
+	}
+	sort.Slice(types, func(i, j int) bool {
+		return s.types[types[i]][0] < s.types[types[j]][0]
+	})
+
+	var entries []RegistryEntry
+	for _, t := range types {
+		name, _ := messageName(reflect.New(t).Interface())
+		for _, u := range s.types[t] {
+			entries = append(entries, RegistryEntry{URL: u, ProtoFullName: name})
+		}
+	}
+	return entries
+}
+
+// ImportRegistry registers the entries returned by ExportRegistry, resolving
+// the full name of each message with the google.golang.org/protobuf registry
+// and then the github.com/gogo/protobuf registry. Entries for a type already
+// holding a url are registered as aliases. An error is returned for names
+// which cannot be resolved and urls which cannot be registered; entries before
+// the failing one remain registered.
+func ImportRegistry(entries []RegistryEntry) error {
+	return DefaultRegistry.ImportRegistry(entries)
+}
+
+// ImportRegistry registers the entries returned by ExportRegistry with the
+// registry. See ImportRegistry.
+func (r *Registry) ImportRegistry(entries []RegistryEntry) error {
+	for _, e := range entries {
+		t, err := messageTypeByName(e.ProtoFullName)
+		if err != nil {
+			return fmt.Errorf("url %q: %w", e.URL, err)
+		}
+		if err := r.importEntry(t, e.URL); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// importEntry registers url for t, returning the panics of registration as
+// errors.
+func (r *Registry) importEntry(t reflect.Type, url string) (err error) {
+	defer recoverRegistration(&err, url)
+	if i := strings.Index(url, "?"+versionParam+"="); i >= 0 {
+		version, err := strconv.Atoi(url[i+len(versionParam)+2:])
+		if err != nil {
+			return fmt.Errorf("invalid version in url %q: %w", url, err)
+		}
+		r.RegisterVersioned(reflect.New(t).Interface(), url[:i], version)
+		return nil
+	}
+	if _, ok := r.load().types[t]; ok {
+		r.RegisterAlias(reflect.New(t).Interface(), url)
+		return nil
+	}
+	return r.tryRegister(t, url)
+}
+
+// messageTypeByName returns the type of the message with the full name.
+func messageTypeByName(name string) (reflect.Type, error) {
+	if mt, err := protoregistry.GlobalTypes.FindMessageByName(protoreflect.FullName(name)); err == nil {
+		return reflect.TypeOf(mt.New().Interface()).Elem(), nil
+	}
+	if t := gogoproto.MessageType(name); t != nil {
+		return t.Elem(), nil
+	}
+	return nil, fmt.Errorf("message %q: %w", name, ErrNotFound)
+}
@@ -0,0 +1,44 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import "fmt"
+
+// Marshal marshals the value v into an any with the correct TypeUrl. It is
+// identical to MarshalAny, but preserves the static type of v.
+func Marshal[T any](v T) (Any, error) {
+	return MarshalAny(v)
+}
+
+// Unmarshal unmarshals the any type into a concrete type T. It is identical
+// to UnmarshalAny, but returns an error instead of requiring a type assertion
+// when the unmarshaled value is not of type T.
+func Unmarshal[T any](any Any) (T, error) {
+	var out T
+	v, err := UnmarshalAny(any)
+	if err != nil {
+		return out, err
+	}
+	if v == nil {
+		return out, nil
+	}
+	out, ok := v.(T)
+	if !ok {
+		return out, fmt.Errorf("unmarshaled type %T is not %T", v, out)
+	}
+	return out, nil
+}
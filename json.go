@@ -0,0 +1,185 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/gogo/protobuf/jsonpb"
+	gogoproto "github.com/gogo/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const typeField = "@type"
+
+// wellKnownJSON lists the well-known types whose JSON mapping is not an
+// object, which are held in a "value" field of the JSON of an Any.
+var wellKnownJSON = map[string]bool{
+	"google.protobuf.Any":         true,
+	"google.protobuf.Duration":    true,
+	"google.protobuf.Timestamp":   true,
+	"google.protobuf.FieldMask":   true,
+	"google.protobuf.Struct":      true,
+	"google.protobuf.Value":       true,
+	"google.protobuf.ListValue":   true,
+	"google.protobuf.DoubleValue": true,
+	"google.protobuf.FloatValue":  true,
+	"google.protobuf.Int64Value":  true,
+	"google.protobuf.UInt64Value": true,
+	"google.protobuf.Int32Value":  true,
+	"google.protobuf.UInt32Value": true,
+	"google.protobuf.BoolValue":   true,
+	"google.protobuf.StringValue": true,
+	"google.protobuf.BytesValue":  true,
+}
+
+// MarshalJSON encodes the Any in the JSON mapping of protocol buffer Any
+// messages, as an object holding the decoded fields of the value along with
+// its type url in an "@type" field. Values whose JSON is not an object, such
+// as well-known types like google.protobuf.Duration, are held in a "value"
+// field instead. The type url is recorded without the encoding of the value.
+// An Any with neither a type url nor a value encodes as null.
+//
+// encoding/json provides no way to pass a registry, so types are always
+// resolved using DefaultRegistry, even for an Any returned by another
+// Registry. Such Anys only encode if their type urls resolve the same way in
+// DefaultRegistry; otherwise, decode them with the registry and marshal the
+// value instead.
+func (a *anyType) MarshalJSON() ([]byte, error) {
+	if a == nil {
+		return []byte("null"), nil
+	}
+	r := DefaultRegistry
+	v, err := r.UnmarshalAny(a)
+	if err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return []byte("null"), nil
+	}
+	var data []byte
+	switch t := v.(type) {
+	case proto.Message:
+		data, err = protojson.Marshal(t)
+	case gogoproto.Message:
+		var buf bytes.Buffer
+		err = (&jsonpb.Marshaler{}).Marshal(&buf, t)
+		data = buf.Bytes()
+	default:
+		data, err = json.Marshal(v)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	url := parseTypeURL(a.typeURL).url
+	fields := make(map[string]json.RawMessage)
+	if wrapJSON(v) {
+		fields["value"] = data
+	} else if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("failed to encode type %q: %w", url, err)
+	}
+	typeData, err := json.Marshal(url)
+	if err != nil {
+		return nil, err
+	}
+	fields[typeField] = typeData
+	return json.Marshal(fields)
+}
+
+// UnmarshalJSON decodes the JSON mapping of an Any produced by MarshalJSON,
+// resolving the type in its "@type" field with DefaultRegistry and
+// marshaling the value as MarshalAny does. As with MarshalJSON, the registry
+// cannot be chosen; use Registry.UnmarshalJSONAny to decode with another.
+func (a *anyType) UnmarshalJSON(data []byte) error {
+	r := DefaultRegistry
+	v, err := r.UnmarshalJSONAny(data)
+	if err != nil {
+		return err
+	}
+	any, err := r.MarshalAny(v)
+	if err != nil {
+		return err
+	}
+	a.typeURL, a.value = any.GetTypeUrl(), any.GetValue()
+	return nil
+}
+
+// UnmarshalJSONAny decodes the JSON mapping of an Any, as produced by
+// protojson or MarshalJSON, into a value of the type named by its "@type"
+// field. The value is held in the remaining fields, or in a "value" field for
+// types whose JSON is not an object. An error is returned if the "@type" field
+// is missing or names a type which cannot be resolved.
+func UnmarshalJSONAny(data []byte) (interface{}, error) {
+	return DefaultRegistry.UnmarshalJSONAny(data)
+}
+
+// UnmarshalJSONAny decodes the JSON mapping of an Any, resolving the type in
+// its "@type" field with the registry. See UnmarshalJSONAny.
+func (r *Registry) UnmarshalJSONAny(data []byte) (interface{}, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	var url string
+	if err := json.Unmarshal(fields[typeField], &url); err != nil || url == "" {
+		return nil, errors.New("missing " + typeField + " field")
+	}
+	delete(fields, typeField)
+
+	t, err := r.getTypeByUrl(parseTypeURL(url).url)
+	if err != nil {
+		return nil, fmt.Errorf("cannot resolve %s %q: %w", typeField, url, err)
+	}
+	v := reflect.New(t.t).Interface()
+	body := []byte(fields["value"])
+	if !wrapJSON(v) {
+		if body, err = json.Marshal(fields); err != nil {
+			return nil, err
+		}
+	}
+	switch m := v.(type) {
+	case proto.Message:
+		err = protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(body, m)
+	case gogoproto.Message:
+		err = (&jsonpb.Unmarshaler{AllowUnknownFields: true}).Unmarshal(bytes.NewReader(body), m)
+	default:
+		err = json.Unmarshal(body, v)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal type %q: %w", url, err)
+	}
+	return v, nil
+}
+
+// wrapJSON returns true if the JSON of v is held in a "value" field.
+func wrapJSON(v interface{}) bool {
+	switch t := v.(type) {
+	case proto.Message:
+		return wellKnownJSON[string(t.ProtoReflect().Descriptor().FullName())]
+	case gogoproto.Message:
+		return wellKnownJSON[gogoproto.MessageName(t)]
+	default:
+		k := reflect.TypeOf(v).Elem().Kind()
+		return k != reflect.Struct && k != reflect.Map
+	}
+}
@@ -0,0 +1,92 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// MarshalAnyMasked marshals the fields of the protocol buffer message v named
+// by mask into an any, clearing all other fields. Paths may name fields of
+// nested messages, such as "metadata.name". v is not modified.
+//
+// Field masks only apply to google.golang.org/protobuf messages, so an error is
+// returned for any other value, as it is for masks naming unknown fields.
+func MarshalAnyMasked(v interface{}, mask *fieldmaskpb.FieldMask) (Any, error) {
+	return DefaultRegistry.MarshalAnyMasked(v, mask)
+}
+
+// MarshalAnyMasked marshals the fields of v named by mask into an any, using
+// the type urls of the registry. See MarshalAnyMasked.
+func (r *Registry) MarshalAnyMasked(v interface{}, mask *fieldmaskpb.FieldMask) (Any, error) {
+	m, ok := v.(proto.Message)
+	if !ok || isNilMessage(m) {
+		return nil, fmt.Errorf("field masks require a protocol buffer message, got %T", v)
+	}
+	if !mask.IsValid(m) {
+		return nil, fmt.Errorf("invalid field mask %v for %s", mask.GetPaths(), m.ProtoReflect().Descriptor().FullName())
+	}
+	m = proto.Clone(m)
+	pruneMessage(m.ProtoReflect(), mask.GetPaths())
+	return r.MarshalAny(m)
+}
+
+// pruneMessage clears the fields of m not named by paths.
+func pruneMessage(m protoreflect.Message, paths []string) {
+	// subpaths maps the names of masked fields to the paths within them,
+	// or to nil when the whole field is kept.
+	subpaths := make(map[protoreflect.Name][]string)
+	for _, p := range paths {
+		name, rest := p, ""
+		if i := strings.IndexByte(p, '.'); i >= 0 {
+			name, rest = p[:i], p[i+1:]
+		}
+		n := protoreflect.Name(name)
+		sub, seen := subpaths[n]
+		switch {
+		case rest == "":
+			subpaths[n] = nil
+		case !seen || sub != nil:
+			subpaths[n] = append(sub, rest)
+		}
+	}
+
+	var cleared []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		sub, ok := subpaths[fd.Name()]
+		switch {
+		case !ok:
+			cleared = append(cleared, fd)
+		case sub != nil:
+			pruneMessage(v.Message(), sub)
+		}
+		return true
+	})
+	for _, fd := range cleared {
+		m.Clear(fd)
+	}
+}
+
+// isNilMessage returns true if m is a typed nil message.
+func isNilMessage(m proto.Message) bool {
+	return !m.ProtoReflect().IsValid()
+}
@@ -0,0 +1,16 @@
+module github.com/containerd/typeurl/v2/msgpack
+
+go 1.18
+
+require (
+	github.com/containerd/typeurl/v2 v2.0.0
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	google.golang.org/protobuf v1.27.1
+)
+
+require (
+	github.com/gogo/protobuf v1.3.2 // indirect
+	github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
+)
+
+replace github.com/containerd/typeurl/v2 => ../
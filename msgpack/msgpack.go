@@ -0,0 +1,51 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+// Package msgpack provides a typeurl.Codec encoding values with MessagePack.
+// It is a separate module so that importers of typeurl which do not use it
+// do not depend on the MessagePack implementation.
+//
+// The codec is not used unless registered:
+//
+//	typeurl.RegisterCodec(msgpack.Codec{})
+package msgpack
+
+import (
+	"github.com/containerd/typeurl/v2"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Codec encodes values with MessagePack, which is typically more compact than
+// JSON for values with many numeric fields. Register it with
+// typeurl.RegisterCodec or typeurl.SetDefaultCodec.
+type Codec struct{}
+
+var _ typeurl.Codec = Codec{}
+
+// Name returns "msgpack".
+func (Codec) Name() string {
+	return "msgpack"
+}
+
+// Marshal returns the MessagePack encoding of v.
+func (Codec) Marshal(v interface{}) ([]byte, error) {
+	return msgpack.Marshal(v)
+}
+
+// Unmarshal decodes the MessagePack encoded data into v.
+func (Codec) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
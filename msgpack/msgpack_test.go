@@ -0,0 +1,53 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package msgpack
+
+import (
+	"testing"
+
+	"github.com/containerd/typeurl/v2"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+type test struct {
+	Name string
+	Age  int
+}
+
+func TestCodec(t *testing.T) {
+	r := typeurl.NewRegistry()
+	r.Register(&test{}, "test")
+	typeurl.RegisterCodec(Codec{})
+
+	any, err := r.MarshalAnyWith(&test{Name: "koye", Age: 6}, Codec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if any.GetTypeUrl() != "test+msgpack" {
+		t.Fatalf("expected %q but received %q", "test+msgpack", any.GetTypeUrl())
+	}
+	nv, err := r.UnmarshalAny(any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if td := nv.(*test); td.Name != "koye" || td.Age != 6 {
+		t.Fatalf("unexpected value %+v", td)
+	}
+	if ct := r.ContentType(&anypb.Any{TypeUrl: "test+msgpack"}); ct != "application/msgpack" {
+		t.Fatalf("expected application/msgpack, got %q", ct)
+	}
+}
@@ -0,0 +1,98 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+
+	"github.com/gogo/protobuf/jsonpb"
+	gogoproto "github.com/gogo/protobuf/proto"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultSprintLimit is the number of bytes Sprint truncates its output to.
+const DefaultSprintLimit = 4096
+
+// Sprint renders any for logging as its type url followed by its value as
+// indented JSON, truncated to DefaultSprintLimit bytes. Values which cannot be
+// decoded are rendered as base64 instead. Sprint never panics, whatever the
+// contents of any.
+func Sprint(any Any) string {
+	return DefaultRegistry.SprintN(any, DefaultSprintLimit)
+}
+
+// SprintN renders any in the same way as Sprint, truncating the output to
+// limit bytes, or fewer where the limit would split a UTF-8 character. A limit
+// of zero or less disables truncation.
+func SprintN(any Any, limit int) string {
+	return DefaultRegistry.SprintN(any, limit)
+}
+
+// Sprint renders any for logging, decoding it with the registry. See Sprint.
+func (r *Registry) Sprint(any Any) string {
+	return r.SprintN(any, DefaultSprintLimit)
+}
+
+// SprintN renders any for logging, decoding it with the registry and
+// truncating the output to limit bytes. See SprintN.
+func (r *Registry) SprintN(any Any, limit int) string {
+	if isNil(any) {
+		return "<nil>"
+	}
+	s := any.GetTypeUrl() + " "
+	if b, err := r.indentJSON(any); err == nil {
+		s += string(b)
+	} else {
+		s += base64.StdEncoding.EncodeToString(any.GetValue())
+	}
+	if limit > 0 && len(s) > limit {
+		// back off to the start of a rune rather than splitting it.
+		for limit > 0 && !utf8.RuneStart(s[limit]) {
+			limit--
+		}
+		s = s[:limit] + "..."
+	}
+	return s
+}
+
+// indentJSON decodes any and encodes the value as indented JSON.
+func (r *Registry) indentJSON(any Any) (b []byte, err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("failed to encode type %q: %v", any.GetTypeUrl(), rec)
+		}
+	}()
+	v, err := r.UnmarshalAny(any)
+	if err != nil {
+		return nil, err
+	}
+	switch t := v.(type) {
+	case proto.Message:
+		return protojson.MarshalOptions{Indent: "  "}.Marshal(t)
+	case gogoproto.Message:
+		var buf bytes.Buffer
+		err := (&jsonpb.Marshaler{Indent: "  "}).Marshal(&buf, t)
+		return buf.Bytes(), err
+	default:
+		return json.MarshalIndent(v, "", "  ")
+	}
+}
@@ -0,0 +1,1252 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gogo/protobuf/jsonpb"
+	gogoproto "github.com/gogo/protobuf/proto"
+	gogotypes "github.com/gogo/protobuf/types"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// Registry maps types to the type urls they are marshaled with and resolves
+// type urls back to types when unmarshaling. The package level functions use
+// DefaultRegistry; separate registries keep independent type namespaces, for
+// example to isolate tests or tenants sharing a process.
+//
+// Registrations are expected to be rare compared to lookups, so lookups read
+// an immutable snapshot of the registrations without locking, while each
+// registration copies the snapshot.
+type Registry struct {
+	// DisableProtoFallback restricts unmarshaling to types registered with
+	// the registry, so that type urls of protocol buffer messages which are
+	// only known to the global protobuf registries are not found. This
+	// prevents untrusted input from instantiating arbitrary messages linked
+	// into the binary. It must be set before the registry is used.
+	DisableProtoFallback bool
+
+	// MaxDecodedSize limits the size in bytes of values unmarshaled with the
+	// registry, after decompression, failing with ErrTooLarge beyond it. It
+	// guards against decompression bombs and large values from untrusted
+	// input. Zero means unlimited. It must be set before the registry is used.
+	MaxDecodedSize int
+
+	// NormalizeURLs resolves type urls which differ from a registered url
+	// only by their prefix, comparing the urls after stripping everything up
+	// to and including their last "/". Both "type.googleapis.com/pkg.Type"
+	// and "pkg.Type" then resolve to a type registered under either. When
+	// several registered urls share a name, the first registered is used. It
+	// must be set before the registry is used.
+	NormalizeURLs bool
+
+	// StrictProto restricts registrations to protocol buffer messages, so
+	// that registering any other type, which would be encoded with a codec
+	// such as JSON, panics. It must be set before types are registered.
+	StrictProto bool
+
+	// mu serializes registrations and guards urlFunc, validator and hooks.
+	mu        sync.Mutex
+	state     atomic.Value // *registryState
+	urlFunc   func(reflect.Type) string
+	validator func(string) error
+	hooks     []func(url string, t reflect.Type)
+}
+
+type registryState struct {
+	types map[reflect.Type][]string
+	// byURL resolves registered urls and aliases to their type.
+	byURL map[string]reflect.Type
+	// byName resolves the names of registered urls, without their prefix,
+	// to the type first registered with the name.
+	byName map[string]reflect.Type
+	// order records the sequence in which urls were bound, so that byName
+	// can be rebuilt in registration order.
+	order map[string]uint64
+	seq   uint64
+	// sites records where each type was registered.
+	sites map[reflect.Type]string
+	// prefix is prepended to the type urls of unregistered messages.
+	prefix string
+	// protos counts the registered protocol buffer message types.
+	protos int
+	// versions maps the urls passed to RegisterVersioned to the type
+	// registered for each version.
+	versions map[string]map[int]reflect.Type
+	// resolvers are consulted in order for urls which are not otherwise
+	// resolved.
+	resolvers []func(url string) (reflect.Type, bool)
+}
+
+func (s *registryState) clone() *registryState {
+	c := &registryState{
+		types:     make(map[reflect.Type][]string, len(s.types)+1),
+		byURL:     make(map[string]reflect.Type, len(s.byURL)+1),
+		byName:    make(map[string]reflect.Type, len(s.byName)+1),
+		order:     make(map[string]uint64, len(s.order)+1),
+		seq:       s.seq,
+		sites:     make(map[reflect.Type]string, len(s.sites)+1),
+		prefix:    s.prefix,
+		protos:    s.protos,
+		versions:  make(map[string]map[int]reflect.Type, len(s.versions)+1),
+		resolvers: s.resolvers,
+	}
+	for t, urls := range s.types {
+		c.types[t] = urls
+	}
+	for u, t := range s.byURL {
+		c.byURL[u] = t
+	}
+	for n, t := range s.byName {
+		c.byName[n] = t
+	}
+	for u, seq := range s.order {
+		c.order[u] = seq
+	}
+	for t, site := range s.sites {
+		c.sites[t] = site
+	}
+	// the version maps are copied when modified.
+	for u, vs := range s.versions {
+		c.versions[u] = vs
+	}
+	return c
+}
+
+// bind resolves url, and its name when not already taken, to t.
+func (s *registryState) bind(url string, t reflect.Type) {
+	s.byURL[url] = t
+	s.seq++
+	s.order[url] = s.seq
+	if n := urlName(url); s.byName[n] == nil {
+		s.byName[n] = t
+	}
+}
+
+// urlName returns url stripped of everything up to and including its last
+// "/", such as "pkg.Type" for "type.googleapis.com/pkg.Type".
+func urlName(url string) string {
+	if i := strings.LastIndexByte(url, '/'); i >= 0 {
+		return url[i+1:]
+	}
+	return url
+}
+
+// checkURL panics if url is already bound to a type other than t, which is
+// being registered at site.
+func (s *registryState) checkURL(t reflect.Type, url, site string) {
+	if other, ok := s.byURL[url]; ok && other != t {
+		panic(fmt.Errorf("url %q is already registered to type %s at %s, cannot register type %s at %s",
+			url, other, s.sites[other], t, site))
+	}
+}
+
+// DefaultRegistry is the registry used by the package level functions.
+var DefaultRegistry = NewRegistry()
+
+// NewRegistry returns an empty registry. Registries are independent of
+// DefaultRegistry and of each other, so tests may give each test its own
+// registry and run in parallel without modifying package level state.
+func NewRegistry() *Registry {
+	r := &Registry{
+		urlFunc: DefaultURL,
+	}
+	r.state.Store(&registryState{
+		types:    make(map[reflect.Type][]string),
+		byURL:    make(map[string]reflect.Type),
+		byName:   make(map[string]reflect.Type),
+		order:    make(map[string]uint64),
+		sites:    make(map[reflect.Type]string),
+		versions: make(map[string]map[int]reflect.Type),
+	})
+	return r
+}
+
+// Clone returns a new registry starting from the registrations and settings
+// of r, such as to add types to those of DefaultRegistry without modifying it.
+// Registrations and resolvers added to either registry afterwards do not
+// affect the other. OnRegister hooks are not copied.
+func (r *Registry) Clone() *Registry {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	c := &Registry{
+		DisableProtoFallback: r.DisableProtoFallback,
+		MaxDecodedSize:       r.MaxDecodedSize,
+		NormalizeURLs:        r.NormalizeURLs,
+		StrictProto:          r.StrictProto,
+		urlFunc:              r.urlFunc,
+		validator:            r.validator,
+	}
+	// the state is never modified in place, so it can be shared.
+	c.state.Store(r.load())
+	return c
+}
+
+func (r *Registry) load() *registryState {
+	return r.state.Load().(*registryState)
+}
+
+// Register registers the type of v with the registry. See Register.
+func (r *Registry) Register(v interface{}, args ...string) {
+	t := tryDereference(v)
+	r.register(t, r.urlFor(t, args))
+}
+
+// RegisterType registers the type t with the registry. See RegisterType.
+func (r *Registry) RegisterType(t reflect.Type, args ...string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	r.register(t, r.urlFor(t, args))
+}
+
+// SetDefaultURLFunc sets the function used to derive the URL of types
+// registered without explicit URL elements. See SetDefaultURLFunc.
+func (r *Registry) SetDefaultURLFunc(fn func(reflect.Type) string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.urlFunc = fn
+}
+
+// SetURLPrefix sets the prefix of the type urls of unregistered protocol
+// buffer messages. See SetURLPrefix.
+func (r *Registry) SetURLPrefix(prefix string) {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.load().clone()
+	s.prefix = prefix
+	r.state.Store(s)
+}
+
+func (r *Registry) urlFor(t reflect.Type, args []string) string {
+	if len(args) > 0 {
+		return path.Join(args...)
+	}
+	if t.Name() == "" || t.PkgPath() == "" {
+		panic(fmt.Errorf("cannot register unnamed type %s without a url; provide an explicit url", t))
+	}
+	r.mu.Lock()
+	fn := r.urlFunc
+	r.mu.Unlock()
+	return fn(t)
+}
+
+// SetURLValidator sets the function validating urls registered with the
+// registry. See SetURLValidator.
+func (r *Registry) SetURLValidator(fn func(string) error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.validator = fn
+}
+
+// validate panics if the url validator rejects url.
+func (r *Registry) validate(t reflect.Type, url string) {
+	r.mu.Lock()
+	fn := r.validator
+	r.mu.Unlock()
+	if fn == nil {
+		return
+	}
+	if err := fn(url); err != nil {
+		panic(fmt.Errorf("invalid url %q for type %s: %w", url, t, err))
+	}
+}
+
+func (r *Registry) register(t reflect.Type, p string) {
+	r.registerWith(t, p, nil)
+}
+
+// registerWith registers t as p, applying update to the new state before it
+// is stored so both changes become visible together, before hooks run.
+func (r *Registry) registerWith(t reflect.Type, p string, update func(*registryState)) {
+	if r.StrictProto && !isMessageType(t) {
+		panic(fmt.Errorf("type %s registered as %q is not a protocol buffer message, as StrictProto requires", t, p))
+	}
+	r.validate(t, p)
+	site := callerSite()
+	// hooks run once the registry is unlocked, so they may use it.
+	var hooks []func(string, reflect.Type)
+	defer runHooks(&hooks, p, t)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.load()
+	if urls, ok := s.types[t]; ok {
+		if urls[0] != p {
+			panic(fmt.Errorf("type registered with alternate path %q != %q, registered at %s and %s",
+				urls[0], p, s.sites[t], site))
+		}
+		return
+	}
+	s.checkURL(t, p, site)
+	s = s.clone()
+	s.types[t] = []string{p}
+	s.bind(p, t)
+	if isMessageType(t) {
+		s.protos++
+	}
+	s.sites[t] = site
+	if update != nil {
+		update(s)
+	}
+	r.state.Store(s)
+	hooks = r.hooks
+}
+
+// AddResolver adds a function resolving type urls which are not registered
+// with the registry. See AddResolver.
+func (r *Registry) AddResolver(fn func(url string) (reflect.Type, bool)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.load().clone()
+	s.resolvers = append(s.resolvers[:len(s.resolvers):len(s.resolvers)], fn)
+	r.state.Store(s)
+}
+
+// tryRegister registers t under url, returning the panics of registration as
+// errors.
+func (r *Registry) tryRegister(t reflect.Type, url string) (err error) {
+	defer recoverRegistration(&err, url)
+	r.register(t, url)
+	return nil
+}
+
+// recoverRegistration sets err to the panic of registering url, wrapping the
+// panic when it is an error.
+func recoverRegistration(err *error, url string) {
+	if v := recover(); v != nil {
+		if e, ok := v.(error); ok {
+			*err = fmt.Errorf("failed to register url %q: %w", url, e)
+		} else {
+			*err = fmt.Errorf("failed to register url %q: %v", url, v)
+		}
+	}
+}
+
+// OnRegister adds a function called whenever a url is registered with the
+// registry. See OnRegister.
+func (r *Registry) OnRegister(fn func(url string, t reflect.Type)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks[:len(r.hooks):len(r.hooks)], fn)
+}
+
+func runHooks(hooks *[]func(string, reflect.Type), url string, t reflect.Type) {
+	for _, fn := range *hooks {
+		fn(url, t)
+	}
+}
+
+// RegisterByName registers the type of v with the registry under its full
+// message name. See RegisterByName.
+func (r *Registry) RegisterByName(v interface{}) error {
+	t := tryDereference(v)
+	name, ok := messageName(v)
+	if !ok || name == "" {
+		return fmt.Errorf("type %s is not a protocol buffer message", t)
+	}
+	r.register(t, name)
+	return nil
+}
+
+// RegisterVersioned registers the type of v as the given version of url with
+// the registry. See RegisterVersioned.
+func (r *Registry) RegisterVersioned(v interface{}, url string, version int) {
+	if version <= 0 {
+		panic(fmt.Errorf("version %d of %q must be positive", version, url))
+	}
+	t := tryDereference(v)
+	r.registerWith(t, url+"?"+versionParam+"="+strconv.Itoa(version), func(s *registryState) {
+		s.setVersion(url, version, t)
+	})
+}
+
+// setVersion sets the type of a version of url, or removes the version when t
+// is nil, copying the version map rather than modifying it.
+func (s *registryState) setVersion(url string, version int, t reflect.Type) {
+	vs := make(map[int]reflect.Type, len(s.versions[url])+1)
+	for k, vt := range s.versions[url] {
+		vs[k] = vt
+	}
+	if t == nil {
+		delete(vs, version)
+	} else {
+		vs[version] = t
+	}
+	if len(vs) == 0 {
+		delete(s.versions, url)
+		return
+	}
+	s.versions[url] = vs
+}
+
+// latestVersion returns the type of the highest version of url.
+func (s *registryState) latestVersion(url string) (reflect.Type, bool) {
+	var (
+		latest int
+		t      reflect.Type
+	)
+	for version, vt := range s.versions[url] {
+		if version > latest {
+			latest, t = version, vt
+		}
+	}
+	return t, t != nil
+}
+
+// RegisterAlias registers an additional URL for a type previously registered
+// with the registry. See RegisterAlias.
+func (r *Registry) RegisterAlias(v interface{}, args ...string) {
+	var (
+		t = tryDereference(v)
+		p = path.Join(args...)
+	)
+	r.validate(t, p)
+	var hooks []func(string, reflect.Type)
+	defer runHooks(&hooks, p, t)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.load()
+	urls, ok := s.types[t]
+	if !ok {
+		panic(fmt.Errorf("type %s must be registered before adding alias %q", t, p))
+	}
+	for _, u := range urls {
+		if u == p {
+			return
+		}
+	}
+	s.checkURL(t, p, callerSite())
+	s = s.clone()
+	// copy urls, as the previous state shares its backing array.
+	s.types[t] = append(urls[:len(urls):len(urls)], p)
+	s.bind(p, t)
+	r.state.Store(s)
+	hooks = r.hooks
+}
+
+// Unregister removes the registration of the type of v from the registry.
+// See Unregister.
+func (r *Registry) Unregister(v interface{}) bool {
+	t := tryDereference(v)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.load()
+	urls, ok := s.types[t]
+	if !ok {
+		return false
+	}
+	s = s.clone()
+	for _, u := range urls {
+		delete(s.byURL, u)
+		delete(s.order, u)
+	}
+	// rebind the names of the remaining urls in the order they were bound.
+	remaining := make([]string, 0, len(s.byURL))
+	for u := range s.byURL {
+		remaining = append(remaining, u)
+	}
+	sort.Slice(remaining, func(i, j int) bool {
+		return s.order[remaining[i]] < s.order[remaining[j]]
+	})
+	s.byName = make(map[string]reflect.Type, len(s.byURL))
+	for _, u := range remaining {
+		if n := urlName(u); s.byName[n] == nil {
+			s.byName[n] = s.byURL[u]
+		}
+	}
+	delete(s.types, t)
+	delete(s.sites, t)
+	if isMessageType(t) {
+		s.protos--
+	}
+	for u, vs := range s.versions {
+		for version, vt := range vs {
+			if vt == t {
+				s.setVersion(u, version, nil)
+			}
+		}
+	}
+	r.state.Store(s)
+	return true
+}
+
+// RegistryState is a snapshot of the registrations of a registry, taken by
+// Snapshot and reinstated by Restore.
+type RegistryState struct {
+	s *registryState
+}
+
+// Snapshot returns the current registrations of the registry. See Snapshot.
+func (r *Registry) Snapshot() RegistryState {
+	return RegistryState{s: r.load()}
+}
+
+// Restore replaces the registrations of the registry with those of state.
+// See Restore.
+func (r *Registry) Restore(state RegistryState) {
+	if state.s == nil {
+		panic("typeurl: restoring a RegistryState not returned by Snapshot")
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state.Store(state.s)
+}
+
+// Registered returns a snapshot of the type urls registered with the
+// registry. See Registered.
+func (r *Registry) Registered() map[string]reflect.Type {
+	s := r.load()
+	m := make(map[string]reflect.Type, len(s.byURL))
+	for t, urls := range s.types {
+		for _, u := range urls {
+			m[u] = t
+		}
+	}
+	return m
+}
+
+// MatchPrefix returns the urls registered with the registry which begin with
+// prefix. See MatchPrefix.
+func (r *Registry) MatchPrefix(prefix string) []string {
+	var urls []string
+	for u := range r.load().byURL {
+		if strings.HasPrefix(u, prefix) {
+			urls = append(urls, u)
+		}
+	}
+	sort.Strings(urls)
+	return urls
+}
+
+// URLsFor returns every URL the type of v is registered under in the
+// registry. See URLsFor.
+func (r *Registry) URLsFor(v interface{}) []string {
+	urls, ok := r.load().types[tryDereference(v)]
+	if !ok {
+		return nil
+	}
+	return append([]string(nil), urls...)
+}
+
+// TypeURL returns the type url for a type registered with the registry.
+func (r *Registry) TypeURL(v interface{}) (string, error) {
+	u, ok := r.LookupTypeURL(v)
+	if !ok {
+		return "", fmt.Errorf("type %s: %w", reflect.TypeOf(v), ErrNotFound)
+	}
+	return u, nil
+}
+
+// ElementTypeURL returns the type url for the element type of a slice, using
+// the types registered with the registry. See ElementTypeURL.
+func (r *Registry) ElementTypeURL(slice interface{}) (string, error) {
+	st := reflect.TypeOf(slice)
+	if st == nil || (st.Kind() != reflect.Slice && st.Kind() != reflect.Array) {
+		return "", fmt.Errorf("type %v is not a slice", st)
+	}
+	et := st.Elem()
+	for et.Kind() == reflect.Ptr {
+		et = et.Elem()
+	}
+	return r.TypeURL(reflect.New(et).Interface())
+}
+
+// MustTypeURL returns the type url for a type registered with the registry,
+// panicking if the type is not registered.
+func (r *Registry) MustTypeURL(v interface{}) string {
+	u, err := r.TypeURL(v)
+	if err != nil {
+		panic(err)
+	}
+	return u
+}
+
+// LookupTypeURL returns the type url for a type registered with the registry
+// and whether it was found. See LookupTypeURL.
+func (r *Registry) LookupTypeURL(v interface{}) (string, bool) {
+	v = derefPointers(v)
+	s := r.load()
+	// messages can only be registered under another url when some are
+	// registered, so until then the lookup is skipped.
+	if s.protos == 0 {
+		if u, ok := messageName(v); ok {
+			return s.prefix + u, true
+		}
+	}
+	urls, ok := s.types[tryDereference(v)]
+	if !ok {
+		if u, ok := messageName(v); ok {
+			return s.prefix + u, true
+		}
+		return "", false
+	}
+	return urls[0], true
+}
+
+// derefPointers returns the pointer a pointer to a pointer, such as a **T,
+// ultimately refers to, or a nil *T if any of the pointers is nil. Other values
+// are returned unchanged.
+func derefPointers(v interface{}) interface{} {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr {
+		return v
+	}
+	for rv.Type().Elem().Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			t := rv.Type().Elem()
+			for t.Elem().Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			return reflect.Zero(t).Interface()
+		}
+		rv = rv.Elem()
+	}
+	return rv.Interface()
+}
+
+// messageName returns the full name of v if it is a protocol buffer message.
+func messageName(v interface{}) (string, bool) {
+	switch t := v.(type) {
+	case proto.Message:
+		return string(t.ProtoReflect().Descriptor().FullName()), true
+	case gogoproto.Message:
+		return gogoproto.MessageName(t), true
+	default:
+		return "", false
+	}
+}
+
+// isMessageType returns true if pointers to t are protocol buffer messages.
+func isMessageType(t reflect.Type) bool {
+	_, ok := messageName(reflect.New(t).Interface())
+	return ok
+}
+
+// TypeOf returns the type a type url resolves to in the registry and whether
+// it was found. See TypeOf.
+func (r *Registry) TypeOf(url string) (reflect.Type, bool) {
+	t, err := r.getTypeByUrl(parseTypeURL(url).url)
+	if err != nil {
+		return nil, false
+	}
+	return t.t, true
+}
+
+// Is returns true if the type of the Any is the same as v. See Is.
+func (r *Registry) Is(any Any, v interface{}) bool {
+	// call to check that v is a pointer
+	tryDereference(v)
+	v = derefPointers(v)
+	url, err := r.TypeURL(v)
+	if err != nil {
+		return false
+	}
+	u := parseTypeURL(any.GetTypeUrl()).url
+	return u == url || r.isAlias(u, v) || isProtoURL(u, v)
+}
+
+// IsType returns true if the type url of the Any resolves to the type t. See
+// IsType.
+func (r *Registry) IsType(any Any, t reflect.Type) bool {
+	if isNil(any) {
+		return false
+	}
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	ut, err := r.getTypeByUrl(parseTypeURL(any.GetTypeUrl()).url)
+	if err != nil {
+		return false
+	}
+	return ut.t == t
+}
+
+// SameType returns true if the type urls of a and b resolve to the same type
+// with the registry. See SameType.
+func (r *Registry) SameType(a, b Any) bool {
+	if isNil(a) || isNil(b) {
+		return false
+	}
+	ua, ub := parseTypeURL(a.GetTypeUrl()).url, parseTypeURL(b.GetTypeUrl()).url
+	if ua == ub {
+		return true
+	}
+	ta, err := r.getTypeByUrl(ua)
+	if err != nil {
+		return false
+	}
+	tb, err := r.getTypeByUrl(ub)
+	if err != nil {
+		return false
+	}
+	return ta.t == tb.t
+}
+
+// MarshalAny marshals the value v into an any using the type urls of the
+// registry. See MarshalAny.
+func (r *Registry) MarshalAny(v interface{}) (Any, error) {
+	return r.marshalAny(v, marshalOptions{})
+}
+
+// MustMarshalAny marshals the value v into an any using the type urls of the
+// registry, panicking on error. See MustMarshalAny.
+func (r *Registry) MustMarshalAny(v interface{}) Any {
+	any, err := r.MarshalAny(v)
+	if err != nil {
+		panic(err)
+	}
+	return any
+}
+
+// MarshalAnyCopy marshals the value v into an any using the type urls of the
+// registry, copying v if it is an Any. See MarshalAnyCopy.
+func (r *Registry) MarshalAnyCopy(v interface{}) (Any, error) {
+	if any, ok := v.(Any); ok {
+		return Clone(any), nil
+	}
+	return r.MarshalAny(v)
+}
+
+// MarshalAnyPB marshals the value v into a google.golang.org/protobuf Any
+// using the type urls of the registry. See MarshalAnyPB.
+func (r *Registry) MarshalAnyPB(v interface{}) (*anypb.Any, error) {
+	any, err := r.MarshalAny(v)
+	if err != nil {
+		return nil, err
+	}
+	return ToProto(any), nil
+}
+
+// MarshalAnyGogo marshals the value v into a github.com/gogo/protobuf Any
+// using the type urls of the registry. See MarshalAnyGogo.
+func (r *Registry) MarshalAnyGogo(v interface{}) (*gogotypes.Any, error) {
+	any, err := r.MarshalAny(v)
+	if err != nil {
+		return nil, err
+	}
+	return ToGogo(any), nil
+}
+
+// MarshalAnyDeterministic marshals the value v into an any using the type
+// urls of the registry. See MarshalAnyDeterministic.
+func (r *Registry) MarshalAnyDeterministic(v interface{}) (Any, error) {
+	return r.marshalAny(v, marshalOptions{deterministic: true})
+}
+
+// MarshalAnyProto marshals the protocol buffer message v into an any using
+// the type urls of the registry. See MarshalAnyProto.
+func (r *Registry) MarshalAnyProto(v interface{}) (Any, error) {
+	return r.marshalAny(v, marshalOptions{protoOnly: true})
+}
+
+// MarshalAnyJSON marshals the value v into an any using the type urls of the
+// registry, encoding protocol buffer messages as JSON. See MarshalAnyJSON.
+func (r *Registry) MarshalAnyJSON(v interface{}) (Any, error) {
+	return r.marshalAny(v, marshalOptions{json: true})
+}
+
+// MarshalAnyWith marshals the value v into an any using the type urls of the
+// registry, encoding types which are not protocol buffer messages with codec.
+// See MarshalAnyWith.
+func (r *Registry) MarshalAnyWith(v interface{}, codec Codec) (Any, error) {
+	return r.marshalAny(v, marshalOptions{codec: codec})
+}
+
+// MarshalSize returns the length of the value MarshalAny would produce for v
+// with the registry. See MarshalSize.
+func (r *Registry) MarshalSize(v interface{}) (int, error) {
+	if v == nil {
+		return 0, nil
+	}
+	v = derefPointers(v)
+	if rv := reflect.ValueOf(v); rv.Kind() == reflect.Ptr && rv.IsNil() {
+		// nil values marshal to an empty value, or to a nil Any.
+		if _, ok := v.(Any); ok {
+			return 0, nil
+		}
+		_, err := r.TypeURL(v)
+		return 0, err
+	}
+	switch t := v.(type) {
+	case Any:
+		return len(t.GetValue()), nil
+	case AnyMarshaler:
+		_, data, err := t.MarshalTypeURL()
+		return len(data), err
+	}
+	if _, err := r.TypeURL(v); err != nil {
+		return 0, err
+	}
+	switch t := v.(type) {
+	case proto.Message:
+		return proto.Size(t), nil
+	case gogoproto.Message:
+		return gogoproto.Size(t), nil
+	default:
+		data, err := getDefaultCodec().Marshal(v)
+		return len(data), err
+	}
+}
+
+// MarshalAnySlice marshals each value in vs into an any using the type urls
+// of the registry. See MarshalAnySlice.
+func (r *Registry) MarshalAnySlice(vs []interface{}) ([]Any, error) {
+	var (
+		anys = make([]Any, len(vs))
+		opts = marshalOptions{urls: make(map[reflect.Type]string)}
+	)
+	for i, v := range vs {
+		any, err := r.marshalAny(v, opts)
+		if err != nil {
+			return nil, fmt.Errorf("index %d: %w", i, err)
+		}
+		anys[i] = any
+	}
+	return anys, nil
+}
+
+type marshalOptions struct {
+	deterministic bool
+	protoOnly     bool
+	// json marshals protocol buffer messages to their canonical JSON.
+	json bool
+	// codec overrides the default codec when set.
+	codec Codec
+	// urls caches type urls by type when set.
+	urls map[reflect.Type]string
+}
+
+func (o marshalOptions) typeURL(r *Registry, v interface{}) (string, error) {
+	if o.urls == nil {
+		return r.TypeURL(v)
+	}
+	t := reflect.TypeOf(v)
+	if u, ok := o.urls[t]; ok {
+		return u, nil
+	}
+	u, err := r.TypeURL(v)
+	if err != nil {
+		return "", err
+	}
+	o.urls[t] = u
+	return u, nil
+}
+
+func (r *Registry) marshalAny(v interface{}, opts marshalOptions) (Any, error) {
+	var (
+		marshal func(v interface{}) ([]byte, error)
+		codec   Codec
+	)
+	if v == nil {
+		return nil, nil
+	}
+	v = derefPointers(v)
+	rv := reflect.ValueOf(v)
+	if any, ok := v.(Any); ok && isNil(any) {
+		return nil, nil
+	}
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		url, err := opts.typeURL(r, v)
+		if err != nil {
+			return nil, err
+		}
+		return &anyType{typeURL: url}, nil
+	}
+	switch t := v.(type) {
+	case Any:
+		// avoid reserializing the type if we have an any.
+		return t, nil
+	case AnyMarshaler:
+		// the encoding of the value is up to the type.
+		if opts.protoOnly {
+			return nil, fmt.Errorf("type %s marshals itself rather than as a protocol buffer message", reflect.TypeOf(v))
+		}
+		url, data, err := t.MarshalTypeURL()
+		if err != nil {
+			return nil, err
+		}
+		return &anyType{
+			typeURL: url,
+			value:   data,
+		}, nil
+	// messages generated for google.golang.org/protobuf also implement the
+	// github.com/gogo/protobuf interface, so they are matched by their
+	// ProtoReflect method first.
+	case proto.Message:
+		marshal = func(v interface{}) ([]byte, error) {
+			if opts.json {
+				return protojson.Marshal(t)
+			}
+			return proto.MarshalOptions{Deterministic: opts.deterministic}.Marshal(t)
+		}
+	case gogoproto.Message:
+		marshal = func(v interface{}) ([]byte, error) {
+			if opts.json {
+				var buf bytes.Buffer
+				err := (&jsonpb.Marshaler{}).Marshal(&buf, t)
+				return buf.Bytes(), err
+			}
+			return gogoproto.Marshal(t)
+		}
+	default:
+		if opts.protoOnly {
+			return nil, fmt.Errorf("type %s is not a protocol buffer message", reflect.TypeOf(v))
+		}
+		if opts.codec != nil {
+			codec = opts.codec
+		} else if opts.deterministic || opts.json {
+			codec = JSONCodec{}
+		} else {
+			codec = getDefaultCodec()
+		}
+		marshal = codec.Marshal
+	}
+
+	url, err := opts.typeURL(r, v)
+	if err != nil {
+		return nil, err
+	}
+	if codec != nil {
+		url = codecURL(url, codec)
+	} else if opts.json {
+		url = withSuffix(url, jsonSuffix)
+	}
+
+	data, err := marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &anyType{
+		typeURL: url,
+		value:   data,
+	}, nil
+}
+
+// UnmarshalAny unmarshals the any type into a concrete type resolved by the
+// registry.
+func (r *Registry) UnmarshalAny(any Any) (interface{}, error) {
+	if c, ok := any.(*cachedAny); ok && r.isCached(c) {
+		// the value is not decoded, but must still pass the checks
+		// applied before decoding.
+		if _, err := r.checkValue(parseTypeURL(c.GetTypeUrl()), c.GetValue()); err != nil {
+			return nil, err
+		}
+		return c.decoded, nil
+	}
+	return r.UnmarshalByTypeURL(any.GetTypeUrl(), any.GetValue())
+}
+
+// isCached returns true if the decoded value of c has the type its type url
+// resolves to.
+func (r *Registry) isCached(c *cachedAny) bool {
+	t, err := r.getTypeByUrl(parseTypeURL(c.GetTypeUrl()).url)
+	return err == nil && reflect.TypeOf(c.decoded) == reflect.PtrTo(t.t)
+}
+
+// ResolveAny unmarshals the any type into a concrete type resolved by the
+// registry, returning the type alongside the value. See ResolveAny.
+func (r *Registry) ResolveAny(any Any) (interface{}, reflect.Type, error) {
+	var rt reflect.Type
+	v, err := r.unmarshalWith(any.GetTypeUrl(), any.GetValue(), func(url string, t reflect.Type) (interface{}, error) {
+		rt = t
+		return reflect.New(t).Interface(), nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return v, rt, nil
+}
+
+// UnmarshalAnyTolerant unmarshals the any type into a concrete type resolved
+// by the registry, returning an *UnknownValue for unresolved type urls. See
+// UnmarshalAnyTolerant.
+func (r *Registry) UnmarshalAnyTolerant(any Any) (interface{}, error) {
+	v, err := r.UnmarshalAny(any)
+	if errors.Is(err, ErrNotFound) {
+		return &UnknownValue{
+			TypeURL: any.GetTypeUrl(),
+			Value:   any.GetValue(),
+		}, nil
+	}
+	return v, err
+}
+
+// Validate checks that any can be unmarshaled with the registry. See
+// Validate.
+func (r *Registry) Validate(any Any) error {
+	// decode the value even if any holds a cached decoded value.
+	_, err := r.UnmarshalByTypeURL(any.GetTypeUrl(), any.GetValue())
+	return err
+}
+
+// UnmarshalAnySlice unmarshals each any in anys into a concrete type
+// resolved by the registry. See UnmarshalAnySlice.
+func (r *Registry) UnmarshalAnySlice(anys []Any) ([]interface{}, error) {
+	vs := make([]interface{}, len(anys))
+	for i, any := range anys {
+		if isNil(any) {
+			continue
+		}
+		v, err := r.UnmarshalAny(any)
+		if err != nil {
+			return nil, fmt.Errorf("index %d: %w", i, err)
+		}
+		vs[i] = v
+	}
+	return vs, nil
+}
+
+// UnmarshalToFromPool unmarshals the any type into an instance obtained from
+// get for the type resolved by the registry. See UnmarshalToFromPool.
+func (r *Registry) UnmarshalToFromPool(any Any, get func(reflect.Type) interface{}) (interface{}, error) {
+	return r.unmarshalWith(any.GetTypeUrl(), any.GetValue(), func(url string, t reflect.Type) (interface{}, error) {
+		v := get(t)
+		if vt := reflect.TypeOf(v); vt != reflect.PtrTo(t) {
+			return nil, fmt.Errorf("pool returned %s for type %q, expected %s", vt, url, reflect.PtrTo(t))
+		}
+		return v, nil
+	})
+}
+
+// UnmarshalByTypeURL unmarshals the given type and value into a concrete type
+// resolved by the registry.
+func (r *Registry) UnmarshalByTypeURL(typeURL string, value []byte) (interface{}, error) {
+	return r.unmarshal(typeURL, value, nil)
+}
+
+// UnmarshalTo unmarshals the any type into the out argument, validating its
+// type against the registry. See UnmarshalTo.
+func (r *Registry) UnmarshalTo(any Any, out interface{}) error {
+	return r.UnmarshalToByTypeURL(any.GetTypeUrl(), any.GetValue(), out)
+}
+
+// UnmarshalToByTypeURL unmarshals the given type and value into the out
+// argument, validating its type against the registry. See
+// UnmarshalToByTypeURL.
+func (r *Registry) UnmarshalToByTypeURL(typeURL string, value []byte, out interface{}) error {
+	if rv := reflect.ValueOf(out); rv.Kind() == reflect.Ptr && rv.Elem().Kind() == reflect.Interface {
+		return r.unmarshalToInterface(typeURL, value, rv.Elem())
+	}
+	_, err := r.unmarshal(typeURL, value, out)
+	return err
+}
+
+// unmarshalToInterface unmarshals into a concrete type resolved by the
+// registry and assigns it to the interface iface.
+func (r *Registry) unmarshalToInterface(typeURL string, value []byte, iface reflect.Value) error {
+	v, err := r.unmarshal(typeURL, value, nil)
+	if err != nil || v == nil {
+		return err
+	}
+	cv := reflect.ValueOf(v)
+	if !cv.Type().AssignableTo(iface.Type()) {
+		return &TypeMismatchError{Have: parseTypeURL(typeURL).url, Want: iface.Type().String()}
+	}
+	iface.Set(cv)
+	return nil
+}
+
+func (r *Registry) unmarshal(typeURL string, value []byte, v interface{}) (interface{}, error) {
+	return r.unmarshalWith(typeURL, value, func(url string, t reflect.Type) (interface{}, error) {
+		if v == nil {
+			return reflect.New(t).Interface(), nil
+		}
+		return v, r.checkOut(url, t, v)
+	})
+}
+
+// checkOut validates that the output v provided by the client can hold values
+// of type t, registered as url.
+func (r *Registry) checkOut(url string, t reflect.Type, v interface{}) error {
+	vURL, err := r.TypeURL(v)
+	if err != nil {
+		return err
+	}
+	if url != vURL && t != tryDereference(v) && !isProtoURL(url, v) {
+		return &TypeMismatchError{Have: url, Want: vURL}
+	}
+	return nil
+}
+
+// UnmarshalToFunc unmarshals the any type into the output returned by newOut,
+// validating its type against the registry. See UnmarshalToFunc.
+func (r *Registry) UnmarshalToFunc(any Any, newOut func() interface{}) error {
+	_, err := r.unmarshalWith(any.GetTypeUrl(), any.GetValue(), func(url string, t reflect.Type) (interface{}, error) {
+		v := newOut()
+		return v, r.checkOut(url, t, v)
+	})
+	return err
+}
+
+// unmarshalWith unmarshals value into the output returned by out for the
+// registered url and type resolved from typeURL.
+func (r *Registry) unmarshalWith(typeURL string, value []byte, out func(url string, t reflect.Type) (interface{}, error)) (interface{}, error) {
+	// a nil Any carries neither a type url nor a value.
+	if value == nil && typeURL == "" {
+		return nil, nil
+	}
+
+	e := parseTypeURL(typeURL)
+	url := e.url
+	t, err := r.getTypeByUrl(url)
+	if err != nil {
+		return nil, err
+	}
+	if value, err = r.checkValue(e, value); err != nil {
+		return nil, err
+	}
+	// an empty value is the zero value of the type, whatever the encoding.
+	if len(value) == 0 {
+		return out(url, t.t)
+	}
+
+	v, err := out(url, t.t)
+	if err != nil {
+		return nil, err
+	}
+	return v, decode(e, value, v)
+}
+
+// checkValue verifies the checksum and size of value, returning it
+// decompressed when its url records compression.
+func (r *Registry) checkValue(e encodedURL, value []byte) ([]byte, error) {
+	if err := verifyChecksum(e, value); err != nil {
+		return nil, err
+	}
+	if len(value) == 0 {
+		return value, nil
+	}
+	if r.MaxDecodedSize > 0 && len(value) > r.MaxDecodedSize {
+		return nil, fmt.Errorf("type %q: %w", e.url, ErrTooLarge)
+	}
+	if e.compressed {
+		value, err := decompress(value, r.MaxDecodedSize)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decompress type %q: %w", e.url, err)
+		}
+		return value, nil
+	}
+	return value, nil
+}
+
+// decode unmarshals value into v, converting any panic raised while decoding
+// malformed input into an error. Unknown fields, such as those added by newer
+// versions of a message, are not an error.
+func decode(e encodedURL, value []byte, v interface{}) (err error) {
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("failed to unmarshal type %q: %v", e.url, rec)
+		}
+	}()
+	switch t := v.(type) {
+	case AnyUnmarshaler:
+		return t.UnmarshalTypeURL(value)
+	case proto.Message:
+		if e.json {
+			return protojson.UnmarshalOptions{DiscardUnknown: true}.Unmarshal(value, t)
+		}
+		return proto.Unmarshal(value, t)
+	case gogoproto.Message:
+		if e.json {
+			return (&jsonpb.Unmarshaler{AllowUnknownFields: true}).Unmarshal(bytes.NewReader(value), t)
+		}
+		return gogoproto.Unmarshal(value, t)
+	default:
+		return e.codec.Unmarshal(value, v)
+	}
+}
+
+// isAlias returns true if url is a registered alias of the type of v.
+func (r *Registry) isAlias(url string, v interface{}) bool {
+	for _, u := range r.load().types[tryDereference(v)] {
+		if u == url {
+			return true
+		}
+	}
+	return false
+}
+
+type urlType struct {
+	t reflect.Type
+}
+
+func (r *Registry) getTypeByUrl(url string) (urlType, error) {
+	s := r.load()
+	if t, ok := s.byURL[url]; ok {
+		return urlType{
+			t: t,
+		}, nil
+	}
+	if t, ok := s.latestVersion(url); ok {
+		return urlType{t: t}, nil
+	}
+	if r.NormalizeURLs {
+		if t, ok := s.byName[urlName(url)]; ok {
+			return urlType{t: t}, nil
+		}
+	}
+	if !r.DisableProtoFallback {
+		if t, ok := protoTypeByURL(url); ok {
+			return urlType{t: t}, nil
+		}
+	}
+	for _, resolve := range s.resolvers {
+		if t, ok := resolve(url); ok && t != nil {
+			if t.Kind() == reflect.Ptr {
+				t = t.Elem()
+			}
+			return urlType{t: t}, nil
+		}
+	}
+	return urlType{}, fmt.Errorf("type with url %s: %w", url, ErrNotFound)
+}
+
+// protoTypeByURL resolves url with the global protobuf registries.
+func protoTypeByURL(url string) (reflect.Type, bool) {
+	if t := gogoproto.MessageType(url); t != nil {
+		// get the underlying Elem because proto returns a pointer to the type
+		return t.Elem(), true
+	}
+	mt, err := protoregistry.GlobalTypes.FindMessageByURL(url)
+	if err != nil {
+		// gogo/protobuf names messages without a url prefix.
+		if i := strings.LastIndexByte(url, '/'); i >= 0 {
+			if t := gogoproto.MessageType(url[i+1:]); t != nil {
+				return t.Elem(), true
+			}
+		}
+		return nil, false
+	}
+	empty := mt.New().Interface()
+	return reflect.TypeOf(empty).Elem(), true
+}
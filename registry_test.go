@@ -0,0 +1,740 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	gogotypes "github.com/gogo/protobuf/types"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestRegistryIsolation(t *testing.T) {
+	r := NewRegistry()
+	r.Register(&test{}, "test")
+
+	if _, err := NewRegistry().TypeURL(&test{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound from an empty registry, got %v", err)
+	}
+
+	in := &test{Name: "koye", Age: 6}
+	any, err := r.MarshalAny(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if any.GetTypeUrl() != "test" {
+		t.Fatalf("expected %q but received %q", "test", any.GetTypeUrl())
+	}
+	out, err := r.UnmarshalAny(any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("round trip failed %v != %v", out, in)
+	}
+	if _, err := NewRegistry().UnmarshalAny(any); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound from an empty registry, got %v", err)
+	}
+}
+
+func TestRegistryConflictingURLs(t *testing.T) {
+	r1, r2 := NewRegistry(), NewRegistry()
+	r1.Register(&test{}, "a")
+	r2.Register(&test{}, "b")
+
+	for expected, r := range map[string]*Registry{"a": r1, "b": r2} {
+		url, err := r.TypeURL(&test{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if url != expected {
+			t.Fatalf("expected %q but received %q", expected, url)
+		}
+		if _, err := r.UnmarshalByTypeURL(expected, []byte("{}")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// conflicts are still detected within a single registry.
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("registering the same type with different urls should panic")
+		}
+	}()
+	r1.Register(&test{}, "b")
+}
+
+func TestRegisterFileDescriptor(t *testing.T) {
+	r := NewRegistry()
+	r.Register(&descriptorpb.FileDescriptorProto{}, "google.protobuf.FileDescriptorProto")
+	if err := r.RegisterFileDescriptor(descriptorpb.File_google_protobuf_descriptor_proto); err != nil {
+		t.Fatal(err)
+	}
+	// registering again is a no-op.
+	if err := r.RegisterFileDescriptor(descriptorpb.File_google_protobuf_descriptor_proto); err != nil {
+		t.Fatal(err)
+	}
+
+	m := r.Registered()
+	for url, expected := range map[string]reflect.Type{
+		"google.protobuf.FileDescriptorProto":            reflect.TypeOf(descriptorpb.FileDescriptorProto{}),
+		"google.protobuf.DescriptorProto":                reflect.TypeOf(descriptorpb.DescriptorProto{}),
+		"google.protobuf.DescriptorProto.ExtensionRange": reflect.TypeOf(descriptorpb.DescriptorProto_ExtensionRange{}),
+	} {
+		if m[url] != expected {
+			t.Fatalf("expected %q to be registered as %s, got %v", url, expected, m[url])
+		}
+	}
+
+	conflict := NewRegistry()
+	conflict.Register(&descriptorpb.DescriptorProto{}, "types.example.com/Descriptor")
+	if err := conflict.RegisterFileDescriptor(descriptorpb.File_google_protobuf_descriptor_proto); err == nil {
+		t.Fatal("expected an error for a message registered under another url")
+	}
+	conflict = NewRegistry()
+	conflict.Register(&test{}, "google.protobuf.FileDescriptorSet")
+	if err := conflict.RegisterFileDescriptor(descriptorpb.File_google_protobuf_descriptor_proto); err == nil {
+		t.Fatal("expected an error for a full name registered to another type")
+	}
+}
+
+func TestRegistryConcurrentAccess(t *testing.T) {
+	r := NewRegistry()
+	r.Register(&test{}, "test")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if url, ok := r.LookupTypeURL(&test{}); !ok || url != "test" {
+					t.Errorf("expected %q, got %q", "test", url)
+					return
+				}
+			}
+		}()
+	}
+	for j := 0; j < 100; j++ {
+		r.RegisterAlias(&test{}, "alias", string(rune('a'+j%26)))
+	}
+	wg.Wait()
+}
+
+// mutexRegistry is the map and mutex lookup previously used by Registry, kept
+// as a baseline for BenchmarkLookupTypeURLParallel.
+type mutexRegistry struct {
+	mu    sync.RWMutex
+	types map[reflect.Type][]string
+}
+
+func (r *mutexRegistry) LookupTypeURL(v interface{}) (string, bool) {
+	r.mu.RLock()
+	urls, ok := r.types[tryDereference(v)]
+	r.mu.RUnlock()
+	if !ok {
+		return "", false
+	}
+	return urls[0], true
+}
+
+func BenchmarkLookupTypeURLParallel(b *testing.B) {
+	b.Run("mutex", func(b *testing.B) {
+		r := &mutexRegistry{types: map[reflect.Type][]string{
+			reflect.TypeOf(test{}): {"test"},
+		}}
+		v := &test{}
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				r.LookupTypeURL(v)
+			}
+		})
+	})
+	b.Run("snapshot", func(b *testing.B) {
+		r := NewRegistry()
+		r.Register(&test{}, "test")
+		v := &test{}
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				r.LookupTypeURL(v)
+			}
+		})
+	})
+}
+
+func TestRegistrationSite(t *testing.T) {
+	r := NewRegistry()
+	if _, ok := r.RegistrationSite(&test{}); ok {
+		t.Fatal("unregistered type should have no registration site")
+	}
+
+	r.Register(&test{}, "test")
+	site, ok := r.RegistrationSite(&test{})
+	if !ok || !strings.Contains(site, "registry_test.go:") {
+		t.Fatalf("expected a site in registry_test.go, got %q", site)
+	}
+
+	defer func() {
+		err, _ := recover().(error)
+		if err == nil {
+			t.Fatal("registering a conflicting type should panic")
+		}
+		if strings.Count(err.Error(), "registry_test.go:") != 2 {
+			t.Fatalf("expected both registration sites in %q", err)
+		}
+	}()
+	r.Register(&test2{}, "test")
+}
+
+func TestOnRegister(t *testing.T) {
+	r := NewRegistry()
+	r.Register(&test2{}, "test2")
+
+	registered := make(map[string]reflect.Type)
+	r.OnRegister(func(url string, rt reflect.Type) {
+		// the registry is updated and usable by the time hooks run.
+		if got, ok := r.TypeOf(url); !ok || got != rt {
+			t.Errorf("expected %q to resolve to %s, got %v", url, rt, got)
+		}
+		registered[url] = rt
+	})
+	r.Register(&test{}, "test")
+	r.Register(&test{}, "test")
+	r.RegisterAlias(&test{}, "legacy.test")
+
+	expected := map[string]reflect.Type{
+		"test":        reflect.TypeOf(test{}),
+		"legacy.test": reflect.TypeOf(test{}),
+	}
+	if !reflect.DeepEqual(registered, expected) {
+		t.Fatalf("expected %v, got %v", expected, registered)
+	}
+}
+
+func TestDisableProtoFallback(t *testing.T) {
+	r := NewRegistry()
+	r.DisableProtoFallback = true
+
+	value, err := proto.Marshal(timestamppb.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.UnmarshalByTypeURL("google.protobuf.Timestamp", value); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := r.UnmarshalByTypeURL("google.protobuf.Duration", []byte{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	r.Register(&timestamppb.Timestamp{}, "google.protobuf.Timestamp")
+	v, err := r.UnmarshalByTypeURL("google.protobuf.Timestamp", value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := v.(*timestamppb.Timestamp); !ok {
+		t.Fatalf("unexpected type %T", v)
+	}
+}
+
+func TestMaxDecodedSize(t *testing.T) {
+	r := NewRegistry()
+	r.MaxDecodedSize = 64
+	r.Register(&test{}, "test")
+
+	small, err := r.MarshalAnyCompressed(&test{Name: "koye"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.UnmarshalAny(small); err != nil {
+		t.Fatal(err)
+	}
+
+	// compresses well below the limit, but decompresses beyond it.
+	bomb, err := r.MarshalAnyCompressed(&test{Name: strings.Repeat("a", 1024)}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bomb.GetValue()) > r.MaxDecodedSize {
+		t.Fatalf("expected the compressed value to fit the limit, got %d bytes", len(bomb.GetValue()))
+	}
+	if _, err := r.UnmarshalAny(bomb); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+
+	large := r.MustMarshalAny(&test{Name: strings.Repeat("a", 64)})
+	if _, err := r.UnmarshalAny(large); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestRegistryClone(t *testing.T) {
+	r := NewRegistry()
+	r.MaxDecodedSize = 1024
+	r.Register(&test{}, "test")
+
+	c := r.Clone()
+	if c.MaxDecodedSize != 1024 {
+		t.Fatalf("expected settings to be copied, got %d", c.MaxDecodedSize)
+	}
+	if url, ok := c.LookupTypeURL(&test{}); !ok || url != "test" {
+		t.Fatalf("expected %q, got %q", "test", url)
+	}
+
+	c.Register(&test2{}, "test2")
+	c.RegisterAlias(&test{}, "legacy.test")
+	if _, ok := r.LookupTypeURL(&test2{}); ok {
+		t.Fatal("registering with the clone should not affect the source")
+	}
+	if urls := r.URLsFor(&test{}); len(urls) != 1 {
+		t.Fatalf("expected the source to keep its urls, got %v", urls)
+	}
+
+	r.Unregister(&test{})
+	if _, ok := c.LookupTypeURL(&test{}); !ok {
+		t.Fatal("unregistering from the source should not affect the clone")
+	}
+}
+
+func TestNormalizeURLs(t *testing.T) {
+	r := NewRegistry()
+	r.Register(&test{}, "pkg.Test")
+	r.Register(&test2{}, "types.example.com/pkg.Test2")
+
+	for _, url := range []string{"type.googleapis.com/pkg.Test", "pkg.Test2"} {
+		if _, err := r.UnmarshalByTypeURL(url, []byte("{}")); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected ErrNotFound for %q without normalization, got %v", url, err)
+		}
+	}
+
+	r.NormalizeURLs = true
+	for url, expected := range map[string]reflect.Type{
+		"type.googleapis.com/pkg.Test":   reflect.TypeOf(&test{}),
+		"pkg.Test2":                      reflect.TypeOf(&test2{}),
+		"other.example.com/pkg.Test2":    reflect.TypeOf(&test2{}),
+		"types.example.com/pkg.Test2":    reflect.TypeOf(&test2{}),
+		"types.example.com/a/b/pkg.Test": reflect.TypeOf(&test{}),
+	} {
+		v, err := r.UnmarshalByTypeURL(url, []byte("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reflect.TypeOf(v) != expected {
+			t.Fatalf("expected %q to resolve to %s, got %T", url, expected, v)
+		}
+	}
+
+	r.Unregister(&test{})
+	if _, err := r.UnmarshalByTypeURL("type.googleapis.com/pkg.Test", []byte("{}")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after unregistering, got %v", err)
+	}
+}
+
+func TestRegisteredMessageURL(t *testing.T) {
+	r := NewRegistry()
+	ts := timestamppb.Now()
+	if url := r.MustTypeURL(ts); url != "google.protobuf.Timestamp" {
+		t.Fatalf("unexpected url %q", url)
+	}
+	r.Register(&timestamppb.Timestamp{}, "types.example.com/Timestamp")
+	if url := r.MustTypeURL(ts); url != "types.example.com/Timestamp" {
+		t.Fatalf("expected the registered url, got %q", url)
+	}
+	r.Unregister(&timestamppb.Timestamp{})
+	if url := r.MustTypeURL(ts); url != "google.protobuf.Timestamp" {
+		t.Fatalf("expected the derived url after unregistering, got %q", url)
+	}
+}
+
+func BenchmarkMarshalAnyMessage(b *testing.B) {
+	ts := timestamppb.Now()
+	registered := NewRegistry()
+	registered.Register(&timestamppb.Timestamp{}, "google.protobuf.Timestamp")
+	for _, bc := range []struct {
+		name string
+		r    *Registry
+	}{
+		{"registered", registered},
+		{"derived", NewRegistry()},
+	} {
+		r := bc.r
+		b.Run(bc.name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := r.MarshalAny(ts); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestRegisterByName(t *testing.T) {
+	r := NewRegistry()
+	for expected, v := range map[string]interface{}{
+		"google.protobuf.Timestamp": &timestamppb.Timestamp{},
+		"google.protobuf.Duration":  &gogotypes.Duration{},
+	} {
+		if err := r.RegisterByName(v); err != nil {
+			t.Fatal(err)
+		}
+		if urls := r.URLsFor(v); len(urls) != 1 || urls[0] != expected {
+			t.Fatalf("expected %q to be registered, got %v", expected, urls)
+		}
+	}
+	if err := r.RegisterByName(&test{}); err == nil {
+		t.Fatal("expected an error registering a type which is not a message")
+	}
+}
+
+func TestSetURLValidator(t *testing.T) {
+	r := NewRegistry()
+	errNoDomain := errors.New("url must contain a domain")
+	r.SetURLValidator(func(url string) error {
+		if !strings.Contains(url, "/") {
+			return errNoDomain
+		}
+		return nil
+	})
+
+	r.Register(&test{}, "types.example.com/test")
+	for name, register := range map[string]func(){
+		"Register":      func() { r.Register(&test2{}, "test2") },
+		"RegisterAlias": func() { r.RegisterAlias(&test{}, "legacy") },
+	} {
+		func() {
+			defer func() {
+				err, _ := recover().(error)
+				if !errors.Is(err, errNoDomain) {
+					t.Fatalf("%s: expected a panic with the validator error, got %v", name, err)
+				}
+			}()
+			register()
+		}()
+	}
+	if _, ok := r.LookupTypeURL(&test2{}); ok {
+		t.Fatal("a rejected type should not be registered")
+	}
+}
+
+func TestMatchPrefix(t *testing.T) {
+	r := NewRegistry()
+	r.Register(&test{}, "types.example.com/events.Created")
+	r.Register(&test2{}, "types.example.com/events.Deleted")
+	r.RegisterAlias(&test{}, "types.example.com/events.Added")
+	r.Register(&timestamppb.Timestamp{}, "types.example.com/time.Timestamp")
+
+	urls := r.MatchPrefix("types.example.com/events.")
+	expected := []string{
+		"types.example.com/events.Added",
+		"types.example.com/events.Created",
+		"types.example.com/events.Deleted",
+	}
+	if !reflect.DeepEqual(urls, expected) {
+		t.Fatalf("expected %v, got %v", expected, urls)
+	}
+	if urls := r.MatchPrefix("types.example.com/none."); len(urls) != 0 {
+		t.Fatalf("expected no urls, got %v", urls)
+	}
+}
+
+func TestStrictProto(t *testing.T) {
+	r := NewRegistry()
+	r.StrictProto = true
+
+	r.Register(&timestamppb.Timestamp{}, "types.example.com/Timestamp")
+	r.Register(&gogotypes.Duration{}, "types.example.com/Duration")
+	func() {
+		defer func() {
+			err, _ := recover().(error)
+			if err == nil || !strings.Contains(err.Error(), "StrictProto") {
+				t.Fatalf("expected a panic naming StrictProto, got %v", err)
+			}
+		}()
+		r.Register(&test{}, "test")
+	}()
+	if _, ok := r.TypeOf("test"); ok {
+		t.Fatal("a rejected type should not be registered")
+	}
+	if !r.Clone().StrictProto {
+		t.Fatal("expected clones to keep StrictProto")
+	}
+}
+
+func TestRegisterVersionedHook(t *testing.T) {
+	r := NewRegistry()
+	r.RegisterVersioned(&descriptorpb.FieldDescriptorProto{}, "types.example.com/Field", 1)
+	var latest reflect.Type
+	r.OnRegister(func(string, reflect.Type) {
+		// the unversioned url resolves to the new version once it is bound.
+		tu, _ := r.getTypeByUrl("types.example.com/Field")
+		latest = tu.t
+	})
+	r.RegisterVersioned(&descriptorpb.FileDescriptorProto{}, "types.example.com/Field", 2)
+	if expected := reflect.TypeOf(descriptorpb.FileDescriptorProto{}); latest != expected {
+		t.Fatalf("expected hook to see %v, got %v", expected, latest)
+	}
+}
+
+func TestExportImportRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.Register(&timestamppb.Timestamp{}, "types.example.com/Timestamp")
+	r.RegisterAlias(&timestamppb.Timestamp{}, "types.example.com/LegacyTimestamp")
+	r.RegisterVersioned(&descriptorpb.FieldDescriptorProto{}, "types.example.com/Field", 2)
+	r.Register(&test{}, "test")
+
+	entries := r.ExportRegistry()
+	expected := []RegistryEntry{
+		{URL: "types.example.com/Field?v=2", ProtoFullName: "google.protobuf.FieldDescriptorProto"},
+		{URL: "types.example.com/Timestamp", ProtoFullName: "google.protobuf.Timestamp"},
+		{URL: "types.example.com/LegacyTimestamp", ProtoFullName: "google.protobuf.Timestamp"},
+	}
+	if !reflect.DeepEqual(entries, expected) {
+		t.Fatalf("expected %v, got %v", expected, entries)
+	}
+
+	imported := NewRegistry()
+	if err := imported.ImportRegistry(entries); err != nil {
+		t.Fatal(err)
+	}
+	for url, expected := range map[string]reflect.Type{
+		"types.example.com/Timestamp":       reflect.TypeOf(timestamppb.Timestamp{}),
+		"types.example.com/LegacyTimestamp": reflect.TypeOf(timestamppb.Timestamp{}),
+		"types.example.com/Field":           reflect.TypeOf(descriptorpb.FieldDescriptorProto{}),
+	} {
+		if typ, ok := imported.TypeOf(url); !ok || typ != expected {
+			t.Fatalf("expected %q to resolve to %v, got %v", url, expected, typ)
+		}
+	}
+	if !reflect.DeepEqual(imported.ExportRegistry(), entries) {
+		t.Fatalf("expected the imported registry to export %v, got %v", entries, imported.ExportRegistry())
+	}
+
+	err := NewRegistry().ImportRegistry([]RegistryEntry{{URL: "unknown", ProtoFullName: "example.Unknown"}})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	conflict := NewRegistry()
+	conflict.Register(&test{}, "types.example.com/Timestamp")
+	if err := conflict.ImportRegistry(entries); err == nil {
+		t.Fatal("expected an error for a url registered to another type")
+	}
+	errInvalid := errors.New("invalid")
+	invalid := NewRegistry()
+	invalid.SetURLValidator(func(string) error { return errInvalid })
+	if err := invalid.ImportRegistry(entries); !errors.Is(err, errInvalid) {
+		t.Fatalf("expected the validator error, got %v", err)
+	}
+}
+
+func TestRegistryParallel(t *testing.T) {
+	for i := 0; i < 4; i++ {
+		i := i
+		t.Run(strconv.Itoa(i), func(t *testing.T) {
+			t.Parallel()
+			r := NewRegistry()
+			r.Register(&test{}, "test")
+			any, err := r.MarshalAny(&test{Name: strconv.Itoa(i)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			v, err := r.UnmarshalAny(any)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if v.(*test).Name != strconv.Itoa(i) {
+				t.Fatalf("unexpected value %v", v)
+			}
+		})
+	}
+}
+
+func TestAddResolver(t *testing.T) {
+	r := NewRegistry()
+	r.DisableProtoFallback = true
+	var calls []string
+	r.AddResolver(func(url string) (reflect.Type, bool) {
+		calls = append(calls, "first:"+url)
+		return nil, false
+	})
+	r.AddResolver(func(url string) (reflect.Type, bool) {
+		calls = append(calls, "second:"+url)
+		if strings.HasPrefix(url, "schema://") {
+			return reflect.TypeOf(&test{}), true
+		}
+		return nil, false
+	})
+
+	v, err := r.UnmarshalByTypeURL("schema://test", []byte(`{"Name":"koye"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.(*test).Name != "koye" {
+		t.Fatalf("unexpected value %v", v)
+	}
+	if expected := []string{"first:schema://test", "second:schema://test"}; !reflect.DeepEqual(calls, expected) {
+		t.Fatalf("expected resolvers to be called in order %v, got %v", expected, calls)
+	}
+	if _, err := r.UnmarshalByTypeURL("other", []byte("{}")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+
+	calls = nil
+	r.Register(&test2{}, "test2")
+	if _, err := r.UnmarshalByTypeURL("test2", []byte("{}")); err != nil || len(calls) != 0 {
+		t.Fatalf("registered types should be resolved without resolvers, got %v %v", calls, err)
+	}
+
+	// resolvers are restored with the registrations and not shared by clones.
+	state := r.Snapshot()
+	c := r.Clone()
+	resolve := func(url string) (reflect.Type, bool) {
+		return reflect.TypeOf(test{}), url == "late"
+	}
+	r.AddResolver(resolve)
+	if _, err := c.UnmarshalByTypeURL("late", []byte("{}")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the clone not to use the resolver, got %v", err)
+	}
+	if _, err := r.UnmarshalByTypeURL("late", []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	r.Restore(state)
+	if _, err := r.UnmarshalByTypeURL("late", []byte("{}")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected Restore to remove the resolver, got %v", err)
+	}
+}
+
+type status int32
+
+// enumStatus encodes itself as the number of a protocol buffer enum.
+type enumStatus int32
+
+func (s enumStatus) MarshalTypeURL() (string, []byte, error) {
+	data, err := proto.Marshal(wrapperspb.Int32(int32(s)))
+	return "types.example.com/Status", data, err
+}
+
+func (s *enumStatus) UnmarshalTypeURL(data []byte) error {
+	var v wrapperspb.Int32Value
+	if err := proto.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	*s = enumStatus(v.Value)
+	return nil
+}
+
+func TestIntegerTypedef(t *testing.T) {
+	r := NewRegistry()
+	r.Register((*status)(nil), "status")
+	r.Register((*enumStatus)(nil), "types.example.com/Status")
+
+	s := status(2)
+	any, err := r.MarshalAny(&s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(any.GetValue()) != "2" {
+		t.Fatalf("expected the codec to encode the number, got %q", any.GetValue())
+	}
+	v, err := r.UnmarshalAny(any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *v.(*status) != s {
+		t.Fatalf("expected %v, got %v", s, *v.(*status))
+	}
+
+	any, err = r.MarshalAny(enumStatus(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wire wrapperspb.Int32Value
+	if err := proto.Unmarshal(any.GetValue(), &wire); err != nil || wire.Value != 2 {
+		t.Fatalf("expected the enum wire form, got %v: %v", any.GetValue(), err)
+	}
+	v, err = r.UnmarshalAny(any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *v.(*enumStatus) != 2 {
+		t.Fatalf("expected 2, got %v", *v.(*enumStatus))
+	}
+}
+
+func TestVerifyRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.Register(&timestamppb.Timestamp{}, "types.example.com/Timestamp")
+	if err := r.RegisterByName(&descriptorpb.DescriptorProto{}); err != nil {
+		t.Fatal(err)
+	}
+	if errs := r.VerifyRegistry(); len(errs) != 0 {
+		t.Fatalf("expected no errors, got %v", errs)
+	}
+
+	r.Register(&test{}, "test")
+	r.Register(&test2{}, "types.other.com/Timestamp")
+	errNoExample := errors.New("url must be under types.example.com")
+	r.SetURLValidator(func(url string) error {
+		if !strings.HasPrefix(url, "types.example.com/") {
+			return errNoExample
+		}
+		return nil
+	})
+
+	var messages []string
+	for _, err := range r.VerifyRegistry() {
+		messages = append(messages, err.Error())
+	}
+	expected := []string{
+		`invalid url "google.protobuf.DescriptorProto" for type descriptorpb.DescriptorProto: url must be under types.example.com`,
+		`url "test" of type typeurl.test is not qualified with a domain, path or package`,
+		`invalid url "test" for type typeurl.test: url must be under types.example.com`,
+		`url "types.example.com/Timestamp" of type timestamppb.Timestamp shares its name with the urls of 1 other types`,
+		`url "types.other.com/Timestamp" of type typeurl.test2 shares its name with the urls of 1 other types`,
+		`invalid url "types.other.com/Timestamp" for type typeurl.test2: url must be under types.example.com`,
+	}
+	if !reflect.DeepEqual(messages, expected) {
+		t.Fatalf("expected %q, got %q", expected, messages)
+	}
+}
+
+func TestNormalizeURLsUnregisterOrder(t *testing.T) {
+	for i := 0; i < 50; i++ {
+		r := NewRegistry()
+		r.NormalizeURLs = true
+		r.Register(&test{}, "a.example.com/pkg.Type")
+		r.Register(&test2{}, "b.example.com/pkg.Type")
+		r.Register(&timestamppb.Timestamp{}, "c.example.com/pkg.Type")
+		r.Register(&descriptorpb.FieldDescriptorProto{}, "types.example.com/Field")
+
+		r.Unregister(&descriptorpb.FieldDescriptorProto{})
+		if typ, ok := r.TypeOf("pkg.Type"); !ok || typ != reflect.TypeOf(test{}) {
+			t.Fatalf("expected the first registered type to keep the name, got %v", typ)
+		}
+		r.Unregister(&test{})
+		if typ, ok := r.TypeOf("pkg.Type"); !ok || typ != reflect.TypeOf(test2{}) {
+			t.Fatalf("expected the next registered type to take the name, got %v", typ)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import "errors"
+
+const schemaParam = "schema"
+
+// MarshalAnyWithSchema marshals the value v into an any in the same way as
+// MarshalAny, recording schemaID in the query string of the type url, such as
+// "types.example.com/Foo?schema=3f2a9c". The id is opaque to this package; it
+// is typically a hash of the schema the value was produced with, which
+// consumers compare with SchemaID to detect schema skew. It does not affect
+// how the value is resolved or decoded.
+func MarshalAnyWithSchema(v interface{}, schemaID string) (Any, error) {
+	return DefaultRegistry.MarshalAnyWithSchema(v, schemaID)
+}
+
+// MarshalAnyWithSchema marshals the value v using the type urls of the
+// registry, recording schemaID. See MarshalAnyWithSchema.
+func (r *Registry) MarshalAnyWithSchema(v interface{}, schemaID string) (Any, error) {
+	if schemaID == "" {
+		return nil, errors.New("schema id must not be empty")
+	}
+	any, err := r.MarshalAny(v)
+	if err != nil || isNil(any) {
+		return any, err
+	}
+	return &anyType{
+		typeURL: withParam(any.GetTypeUrl(), schemaParam, schemaID),
+		value:   any.GetValue(),
+	}, nil
+}
+
+// SchemaID returns the schema id recorded by MarshalAnyWithSchema in the type
+// url of any, or "" if there is none.
+func SchemaID(any Any) string {
+	if isNil(any) {
+		return ""
+	}
+	return parseTypeURL(any.GetTypeUrl()).params.Get(schemaParam)
+}
@@ -0,0 +1,57 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"strings"
+)
+
+// RegistrationSite returns the file and line, such as "/src/events.go:42", from
+// which the type of v was registered, and whether the type is registered.
+// The site is also included in the panics raised by conflicting registrations.
+func RegistrationSite(v interface{}) (string, bool) {
+	return DefaultRegistry.RegistrationSite(v)
+}
+
+// RegistrationSite returns where the type of v was registered with the
+// registry. See RegistrationSite.
+func (r *Registry) RegistrationSite(v interface{}) (string, bool) {
+	site, ok := r.load().sites[tryDereference(v)]
+	return site, ok
+}
+
+var pkgPrefix = reflect.TypeOf(Registry{}).PkgPath() + "."
+
+// callerSite returns the file and line of the first caller outside of this
+// package.
+func callerSite() string {
+	pcs := make([]uintptr, 16)
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(2, pcs)])
+	for {
+		frame, more := frames.Next()
+		// tests of this package register types from its own functions.
+		if !strings.HasPrefix(frame.Function, pkgPrefix) || strings.HasSuffix(frame.File, "_test.go") {
+			return fmt.Sprintf("%s:%d", frame.File, frame.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
@@ -0,0 +1,187 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Frames written by MarshalAnyTo consist of the type url followed by the
+// value, each prefixed with its length as a big endian uint32.
+
+// chunkBufferSize is the largest chunk allocated up front when reading a
+// frame. Larger chunks are read into a buffer which grows as data arrives, so
+// that a corrupt length cannot allocate more memory than the stream holds.
+const chunkBufferSize = 64 << 10
+
+// MarshalAnyTo marshals the value v as MarshalAny does and writes the type url
+// and value to w as a single length-prefixed frame. The value is marshaled in
+// full before it is written, as MarshalAny does, but is not copied again. The
+// frame can be read back using UnmarshalAnyFrom. A nil v
+// is written as a frame with an empty type url and value, which reads back as
+// nil.
+func MarshalAnyTo(w io.Writer, v interface{}) error {
+	return DefaultRegistry.MarshalAnyTo(w, v)
+}
+
+// MarshalAnyTo marshals the value v using the type urls of the registry and
+// writes it to w as a single frame. See MarshalAnyTo.
+func (r *Registry) MarshalAnyTo(w io.Writer, v interface{}) error {
+	any, err := r.MarshalAny(v)
+	if err != nil {
+		return err
+	}
+	if isNil(any) {
+		return writeFrame(w, "", nil)
+	}
+	return writeFrame(w, any.GetTypeUrl(), any.GetValue())
+}
+
+// UnmarshalAnyFrom reads a single frame written by MarshalAnyTo from r and
+// unmarshals it into a concrete type. io.EOF is returned when r has no more
+// frames. Type urls and values larger than the MaxDecodedSize of the registry
+// fail with ErrTooLarge before they are read.
+func UnmarshalAnyFrom(r io.Reader) (interface{}, error) {
+	return DefaultRegistry.UnmarshalAnyFrom(r)
+}
+
+// UnmarshalAnyFrom reads a single frame from rd and unmarshals it into a
+// concrete type resolved by the registry. See UnmarshalAnyFrom.
+func (r *Registry) UnmarshalAnyFrom(rd io.Reader) (interface{}, error) {
+	typeURL, value, err := readFrame(rd, r.MaxDecodedSize)
+	if err != nil {
+		return nil, err
+	}
+	return r.UnmarshalByTypeURL(typeURL, value)
+}
+
+// AnyReader reads a sequence of frames written by MarshalAnyTo.
+type AnyReader struct {
+	r   io.Reader
+	reg *Registry
+}
+
+// NewAnyReader returns an AnyReader reading frames from r, which unmarshals
+// values using DefaultRegistry.
+func NewAnyReader(r io.Reader) *AnyReader {
+	return DefaultRegistry.NewAnyReader(r)
+}
+
+// NewAnyReader returns an AnyReader reading frames from rd, which unmarshals
+// values using the registry. See NewAnyReader.
+func (r *Registry) NewAnyReader(rd io.Reader) *AnyReader {
+	return &AnyReader{r: rd, reg: r}
+}
+
+// Next reads the next frame as an Any without decoding its value. io.EOF is
+// returned when there are no more frames, and io.ErrUnexpectedEOF if the
+// last frame is truncated. Frames are limited by the MaxDecodedSize of the
+// registry, as in UnmarshalAnyFrom.
+func (ar *AnyReader) Next() (Any, error) {
+	typeURL, value, err := readFrame(ar.r, ar.reg.MaxDecodedSize)
+	if err != nil {
+		return nil, err
+	}
+	return &anyType{
+		typeURL: typeURL,
+		value:   value,
+	}, nil
+}
+
+// DecodeNext reads the next frame and unmarshals it into a concrete type, in
+// the same way as UnmarshalAnyFrom.
+func (ar *AnyReader) DecodeNext() (interface{}, error) {
+	any, err := ar.Next()
+	if err != nil {
+		return nil, err
+	}
+	return ar.reg.UnmarshalAny(any)
+}
+
+func writeFrame(w io.Writer, typeURL string, value []byte) error {
+	if err := writeChunk(w, []byte(typeURL)); err != nil {
+		return err
+	}
+	return writeChunk(w, value)
+}
+
+func writeChunk(w io.Writer, b []byte) error {
+	if uint64(len(b)) > math.MaxUint32 {
+		return fmt.Errorf("frame of %d bytes exceeds maximum size", len(b))
+	}
+	var prefix [4]byte
+	binary.BigEndian.PutUint32(prefix[:], uint32(len(b)))
+	if _, err := w.Write(prefix[:]); err != nil {
+		return err
+	}
+	_, err := w.Write(b)
+	return err
+}
+
+// readFrame reads a frame, failing with ErrTooLarge for chunks larger than
+// limit bytes. A limit of zero or less is unlimited.
+func readFrame(r io.Reader, limit int) (string, []byte, error) {
+	typeURL, err := readChunk(r, limit)
+	if err != nil {
+		return "", nil, err
+	}
+	value, err := readChunk(r, limit)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return "", nil, err
+	}
+	return string(typeURL), value, nil
+}
+
+func readChunk(r io.Reader, limit int) ([]byte, error) {
+	var prefix [4]byte
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(prefix[:])
+	if n == 0 {
+		return nil, nil
+	}
+	if limit > 0 && uint64(n) > uint64(limit) {
+		return nil, fmt.Errorf("frame of %d bytes: %w", n, ErrTooLarge)
+	}
+	if n <= chunkBufferSize {
+		b := make([]byte, n)
+		if _, err := io.ReadFull(r, b); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return b, nil
+	}
+	var buf bytes.Buffer
+	buf.Grow(chunkBufferSize)
+	if _, err := io.CopyN(&buf, r, int64(n)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,156 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalAnyToUnmarshalAnyFrom(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	Register(&test2{}, "test2")
+
+	values := []interface{}{
+		&test{Name: "koye", Age: 6},
+		&test2{Name: "kitty"},
+	}
+
+	var buf bytes.Buffer
+	for _, v := range values {
+		if err := MarshalAnyTo(&buf, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, expected := range values {
+		v, err := UnmarshalAnyFrom(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(v, expected) {
+			t.Fatalf("round trip failed %v != %v", v, expected)
+		}
+	}
+	if _, err := UnmarshalAnyFrom(&buf); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestUnmarshalAnyFromTruncated(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	var buf bytes.Buffer
+	if err := MarshalAnyTo(&buf, &test{Name: "koye", Age: 6}); err != nil {
+		t.Fatal(err)
+	}
+	buf.Truncate(buf.Len() - 1)
+
+	if _, err := UnmarshalAnyFrom(&buf); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+}
+
+func TestAnyReader(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	Register(&test2{}, "test2")
+
+	values := []interface{}{
+		&test{Name: "koye", Age: 6},
+		&test2{Name: "kitty"},
+	}
+	var buf bytes.Buffer
+	for _, v := range values {
+		if err := MarshalAnyTo(&buf, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := buf.Bytes()
+
+	ar := NewAnyReader(bytes.NewReader(b))
+	for _, expected := range []string{"test", "test2"} {
+		any, err := ar.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if any.GetTypeUrl() != expected {
+			t.Fatalf("expected %q but received %q", expected, any.GetTypeUrl())
+		}
+	}
+	if _, err := ar.Next(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+
+	ar = NewAnyReader(bytes.NewReader(b))
+	for _, expected := range values {
+		v, err := ar.DecodeNext()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(v, expected) {
+			t.Fatalf("round trip failed %v != %v", v, expected)
+		}
+	}
+	if _, err := ar.DecodeNext(); err != io.EOF {
+		t.Fatalf("expected io.EOF, got %v", err)
+	}
+}
+
+func TestUnmarshalAnyFromCorruptLength(t *testing.T) {
+	r := NewRegistry()
+	r.Register(&test{}, "test")
+
+	// a frame claiming a type url of 4 GiB, followed by little data.
+	frame := append([]byte{0xff, 0xff, 0xff, 0xff}, "test"...)
+	if _, err := r.UnmarshalAnyFrom(bytes.NewReader(frame)); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("expected io.ErrUnexpectedEOF, got %v", err)
+	}
+
+	r.MaxDecodedSize = 16
+	if _, err := r.NewAnyReader(bytes.NewReader(frame)).Next(); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := r.MarshalAnyTo(&buf, &test{Name: strings.Repeat("k", 32)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.UnmarshalAnyFrom(&buf); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+
+	r.MaxDecodedSize = 0
+	large := &test{Name: strings.Repeat("k", 2*chunkBufferSize)}
+	buf.Reset()
+	if err := r.MarshalAnyTo(&buf, large); err != nil {
+		t.Fatal(err)
+	}
+	v, err := r.UnmarshalAnyFrom(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, large) {
+		t.Fatal("round trip of a large value failed")
+	}
+}
@@ -0,0 +1,67 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"fmt"
+	"time"
+
+	gogotypes "github.com/gogo/protobuf/types"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// MarshalTime marshals t into an any holding a google.protobuf.Timestamp.
+func MarshalTime(t time.Time) (Any, error) {
+	return DefaultRegistry.MarshalTime(t)
+}
+
+// MarshalTime marshals t into an any holding a google.protobuf.Timestamp,
+// using the type urls of the registry. See MarshalTime.
+func (r *Registry) MarshalTime(t time.Time) (Any, error) {
+	ts := timestamppb.New(t)
+	if err := ts.CheckValid(); err != nil {
+		return nil, err
+	}
+	return r.MarshalAny(ts)
+}
+
+// UnmarshalTime unmarshals an any holding a google.protobuf.Timestamp into a
+// time.Time in UTC. The timestamp may have been marshaled from either the
+// google.golang.org/protobuf or the github.com/gogo/protobuf message.
+func UnmarshalTime(any Any) (time.Time, error) {
+	return DefaultRegistry.UnmarshalTime(any)
+}
+
+// UnmarshalTime unmarshals an any holding a google.protobuf.Timestamp into a
+// time.Time, resolving its type url with the registry. See UnmarshalTime.
+func (r *Registry) UnmarshalTime(any Any) (time.Time, error) {
+	v, err := r.UnmarshalAny(any)
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch ts := v.(type) {
+	case *timestamppb.Timestamp:
+		if err := ts.CheckValid(); err != nil {
+			return time.Time{}, err
+		}
+		return ts.AsTime(), nil
+	case *gogotypes.Timestamp:
+		return gogotypes.TimestampFromProto(ts)
+	default:
+		return time.Time{}, fmt.Errorf("type %q is not a timestamp", any.GetTypeUrl())
+	}
+}
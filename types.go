@@ -17,21 +17,15 @@
 package typeurl
 
 import (
-	"encoding/json"
 	"errors"
 	"fmt"
-	"path"
 	"reflect"
-	"sync"
+	"strings"
 
 	gogoproto "github.com/gogo/protobuf/proto"
+	gogotypes "github.com/gogo/protobuf/types"
 	"google.golang.org/protobuf/proto"
-	"google.golang.org/protobuf/reflect/protoregistry"
-)
-
-var (
-	mu       sync.RWMutex
-	registry = make(map[reflect.Type]string)
+	"google.golang.org/protobuf/types/known/anypb"
 )
 
 // Definitions of common error types used throughout typeurl.
@@ -44,8 +38,22 @@ var (
 
 var (
 	ErrNotFound = errors.New("not found")
+	ErrTooLarge = errors.New("value too large")
 )
 
+// TypeMismatchError is returned when unmarshaling into an output whose type
+// url differs from the type url of the value.
+type TypeMismatchError struct {
+	// Have is the type url of the value being unmarshaled.
+	Have string
+	// Want is the type url of the output.
+	Want string
+}
+
+func (e *TypeMismatchError) Error() string {
+	return fmt.Sprintf("can't unmarshal type %q to output %q", e.Have, e.Want)
+}
+
 // Any contains an arbitrary protcol buffer message along with its type.
 //
 // While there is google.golang.org/protobuf/types/known/anypb.Any,
@@ -63,6 +71,26 @@ type Any interface {
 	GetValue() []byte
 }
 
+// AnyMarshaler is implemented by types which marshal themselves into an Any,
+// bypassing protocol buffers and the codecs. MarshalAny uses the returned type
+// url and value verbatim.
+//
+// Registered named types which are not messages, such as "type Status int32",
+// are encoded by the codec, so a pointer to a Status marshals to the JSON
+// number. To match a protocol buffer enum used by other consumers instead,
+// the type can implement AnyMarshaler and AnyUnmarshaler, for example encoding
+// the enum number as a google.protobuf.Int32Value.
+type AnyMarshaler interface {
+	MarshalTypeURL() (string, []byte, error)
+}
+
+// AnyUnmarshaler is implemented by types which unmarshal themselves from the
+// value of an Any. The type must be registered under the type url returned by
+// its MarshalTypeURL method for UnmarshalAny and UnmarshalTo to resolve it.
+type AnyUnmarshaler interface {
+	UnmarshalTypeURL([]byte) error
+}
+
 type anyType struct {
 	typeURL string
 	value   []byte
@@ -86,188 +114,466 @@ func (a *anyType) GetValue() []byte {
 // UnmarshalAny functions are called they will treat the Any type value as JSON.
 // To use protocol buffers for handling the Any value the proto.Register
 // function should be used instead of this function.
+//
+// When no URL elements are provided, the URL is derived from the type by the
+// function set with SetDefaultURLFunc, which defaults to DefaultURL. Types
+// without a name or package path, such as anonymous structs, have no URL to
+// derive and must be registered with explicit URL elements. This includes
+// maps and slices, such as map[string]int, which may be registered for use as
+// top level values. As with structs, they are marshaled from and unmarshaled
+// to pointers, such as *map[string]int.
+//
+// Register panics if the type is already registered with a different URL, or
+// if the URL is already registered to a different type. Levels of pointers
+// beyond the first are ignored, so a **Foo registers Foo as a *Foo does.
 func Register(v interface{}, args ...string) {
-	var (
-		t = tryDereference(v)
-		p = path.Join(args...)
-	)
-	mu.Lock()
-	defer mu.Unlock()
-	if et, ok := registry[t]; ok {
-		if et != p {
-			panic(fmt.Errorf("type registered with alternate path %q != %q", et, p))
+	DefaultRegistry.Register(v, args...)
+}
+
+// RegisterType registers the type t with a base URL for JSON marshaling in the
+// same way as Register, for callers that do not have a value of the type. A
+// pointer type, including a pointer to a pointer, is registered as the type it
+// ultimately points to.
+func RegisterType(t reflect.Type, args ...string) {
+	DefaultRegistry.RegisterType(t, args...)
+}
+
+// DefaultURL derives the URL of a type registered without explicit URL
+// elements. Protocol buffer messages use their full message name, matching
+// the URLs used for unregistered messages. Other types use their package path
+// and name, such as "github.com/containerd/containerd/events.TaskCreate".
+func DefaultURL(t reflect.Type) string {
+	switch m := reflect.New(t).Interface().(type) {
+	case proto.Message:
+		return string(m.ProtoReflect().Descriptor().FullName())
+	case gogoproto.Message:
+		if name := gogoproto.MessageName(m); name != "" {
+			return name
 		}
-		return
 	}
-	registry[t] = p
+	return t.PkgPath() + "." + t.Name()
+}
+
+// SetDefaultURLFunc sets the function used to derive the URL of types which
+// are registered without explicit URL elements. The function is called with
+// the type being registered, never with a pointer to it.
+func SetDefaultURLFunc(fn func(reflect.Type) string) {
+	DefaultRegistry.SetDefaultURLFunc(fn)
+}
+
+// RegisterByName registers the type of v, which must be a protocol buffer
+// message, using its full message name as the URL, such as
+// "google.protobuf.Timestamp". This keeps the URL in step with the message
+// definition. An error is returned for types which are not messages.
+func RegisterByName(v interface{}) error {
+	return DefaultRegistry.RegisterByName(v)
+}
+
+// RegisterVersioned registers the type of v as a version of url, allowing
+// several types to represent versions of the same logical type, such as during
+// a migration. MarshalAny records the version as a parameter of the type url,
+// such as "types.example.com/Config?v=2", and UnmarshalAny decodes values to
+// the type registered for their version. Values without a version decode to
+// the type registered for the highest version. The version must be positive.
+func RegisterVersioned(v interface{}, url string, version int) {
+	DefaultRegistry.RegisterVersioned(v, url, version)
+}
+
+// SetURLPrefix sets the prefix of the type urls of protocol buffer messages
+// which are not registered, such as "type.googleapis.com/". By default they
+// have no prefix. A "/" is appended to the prefix if it does not end in one.
+// Registered types keep the URLs they were registered with.
+//
+// Type urls of messages resolve to their type whatever their prefix, so values
+// marshaled with any prefix can be unmarshaled.
+func SetURLPrefix(prefix string) {
+	DefaultRegistry.SetURLPrefix(prefix)
+}
+
+// SetURLValidator sets a function enforcing a policy on the URLs of types and
+// aliases, such as requiring a domain. Registering a URL the function returns
+// an error for panics with the error. Passing nil removes the validator. URLs
+// registered before the validator is set are not checked.
+func SetURLValidator(fn func(string) error) {
+	DefaultRegistry.SetURLValidator(fn)
+}
+
+// RegisterAlias registers an additional URL for a type previously passed to
+// Register. Any values carrying an alias unmarshal to the type, while
+// MarshalAny and TypeURL continue to use the URL the type was registered with.
+// This allows values persisted under a legacy URL to be read after a type is
+// renamed. Like Register, it panics if the URL belongs to another type.
+func RegisterAlias(v interface{}, args ...string) {
+	DefaultRegistry.RegisterAlias(v, args...)
+}
+
+// AddResolver adds a function consulted, after the registered types and the
+// global protobuf registries, to resolve type urls when unmarshaling, such as
+// to load types for a custom url scheme on demand. Resolvers are consulted in
+// the order they were added until one returns true. They are also consulted
+// when DisableProtoFallback is set, and must be safe for concurrent use.
+// Resolvers are part of the registrations captured by Snapshot and Clone.
+func AddResolver(fn func(url string) (reflect.Type, bool)) {
+	DefaultRegistry.AddResolver(fn)
+}
+
+// OnRegister adds a function called with the url and type whenever Register,
+// RegisterType or RegisterAlias adds a url, such as to publish types
+// registered by plugins. Functions are called after the registry is updated,
+// outside of its lock, and may use it. Registrations made before OnRegister is
+// called are not reported; Registered returns them.
+func OnRegister(fn func(url string, t reflect.Type)) {
+	DefaultRegistry.OnRegister(fn)
 }
 
-// TypeURL returns the type url for a registered type.
+// Unregister removes the registration of the type of v, returning true if a
+// registration was removed. It is safe to call Unregister for a type that
+// was never registered.
+func Unregister(v interface{}) bool {
+	return DefaultRegistry.Unregister(v)
+}
+
+// Snapshot returns the current registrations of DefaultRegistry, including
+// aliases, versions, resolvers and the URL prefix, for reinstating them with
+// Restore. It
+// allows tests outside this package to register types temporarily:
+//
+//	state := typeurl.Snapshot()
+//	defer typeurl.Restore(state)
+//
+// Taking a snapshot is cheap, as registrations are never modified in place.
+func Snapshot() RegistryState {
+	return DefaultRegistry.Snapshot()
+}
+
+// Restore replaces the registrations of DefaultRegistry with those of a
+// snapshot taken by Snapshot, undoing any registration or AddResolver call
+// made since. The default URL function and OnRegister hooks are not affected,
+// and no hooks are called.
+func Restore(state RegistryState) {
+	DefaultRegistry.Restore(state)
+}
+
+// Registered returns a snapshot of all registered type urls and the types
+// they resolve to. The returned map is a copy and may be modified freely.
+func Registered() map[string]reflect.Type {
+	return DefaultRegistry.Registered()
+}
+
+// MatchPrefix returns the registered type urls, including aliases, which
+// begin with prefix, such as "types.example.com/events.", in sorted order.
+func MatchPrefix(prefix string) []string {
+	return DefaultRegistry.MatchPrefix(prefix)
+}
+
+// URLsFor returns every URL the type of v is registered under, starting with
+// the URL returned by TypeURL followed by any aliases. It returns nil if the
+// type is not registered.
+func URLsFor(v interface{}) []string {
+	return DefaultRegistry.URLsFor(v)
+}
+
+// TypeURL returns the type url for a registered type. Protocol buffer
+// messages do not need to be registered; unless they are, their type url is
+// their full message name, such as "google.protobuf.Timestamp".
+//
+// The name is not prefixed with "type.googleapis.com/" by default, so that
+// type urls stay identical to those of Any values already marshaled by
+// earlier versions of this package. Use SetURLPrefix to derive prefixed urls.
 func TypeURL(v interface{}) (string, error) {
-	mu.RLock()
-	u, ok := registry[tryDereference(v)]
-	mu.RUnlock()
-	if !ok {
-		switch t := v.(type) {
-		case proto.Message:
-			return string(t.ProtoReflect().Descriptor().FullName()), nil
-		case gogoproto.Message:
-			return gogoproto.MessageName(t), nil
-		default:
-			return "", fmt.Errorf("type %s: %w", reflect.TypeOf(v), ErrNotFound)
-		}
-	}
-	return u, nil
+	return DefaultRegistry.TypeURL(v)
+}
+
+// ElementTypeURL returns the type url for the element type of slice, such as
+// the url of T for a []*T or []T, in the same way as TypeURL. The element type
+// is taken from the slice type, so slice may be empty or nil.
+func ElementTypeURL(slice interface{}) (string, error) {
+	return DefaultRegistry.ElementTypeURL(slice)
+}
+
+// MustTypeURL returns the type url for a registered type, panicking if the
+// type is not registered. It is intended for initializing package level
+// variables.
+func MustTypeURL(v interface{}) string {
+	return DefaultRegistry.MustTypeURL(v)
+}
+
+// LookupTypeURL returns the type url for a registered type and whether it was
+// found. Unlike TypeURL, it does not allocate an error when the type is not
+// registered.
+func LookupTypeURL(v interface{}) (string, bool) {
+	return DefaultRegistry.LookupTypeURL(v)
 }
 
-// Is returns true if the type of the Any is the same as v.
+// TypeOf returns the type a type url resolves to and whether it was found,
+// without decoding any value. The url is resolved in the same way as
+// UnmarshalAny, through the registered types and then the protocol buffer
+// registries. The type returned is never a pointer type.
+func TypeOf(url string) (reflect.Type, bool) {
+	return DefaultRegistry.TypeOf(url)
+}
+
+// Is returns true if the type of the Any is the same as v, including when the
+// Any carries one of the registered aliases of v. Protocol buffer messages need
+// not be registered; the Any matches them by their full message name, with or
+// without a url prefix such as "type.googleapis.com/".
 func Is(any Any, v interface{}) bool {
-	// call to check that v is a pointer
-	tryDereference(v)
-	url, err := TypeURL(v)
-	if err != nil {
-		return false
-	}
-	return any.GetTypeUrl() == url
+	return DefaultRegistry.Is(any, v)
+}
+
+// IsType returns true if the type url of the Any resolves to the type t. A
+// pointer type is compared as the type it points to. It returns false for a
+// nil Any.
+func IsType(any Any, t reflect.Type) bool {
+	return DefaultRegistry.IsType(any, t)
+}
+
+// SameType returns true if a and b carry values of the same type, regardless
+// of their contents. Type urls are compared after removing the encoding
+// recorded on them, and aliases resolve to the type they were registered for.
+// It returns false if either Any is nil.
+func SameType(a, b Any) bool {
+	return DefaultRegistry.SameType(a, b)
 }
 
 // MarshalAny marshals the value v into an any with the correct TypeUrl.
 // If the provided object is already a proto.Any message, then it will be
 // returned verbatim. If it is of type proto.Message, it will be marshaled as a
-// protocol buffer. Otherwise, the object will be marshaled to json.
+// protocol buffer. Otherwise, the object will be marshaled with the default
+// codec, which is json unless changed by SetDefaultCodec.
+//
+// An Any returned verbatim shares its value with the input, so modifying the
+// bytes of one modifies the other. Use MarshalAnyCopy to avoid this.
+//
+// A nil v, or a nil Any, is marshaled to a nil Any without error, while a nil
+// pointer to a registered type is marshaled to an Any with the type url of the
+// type and an empty value, which unmarshals to the zero value of the type. A
+// pointer to a pointer is marshaled as the pointer it refers to.
 func MarshalAny(v interface{}) (Any, error) {
-	var marshal func(v interface{}) ([]byte, error)
-	switch t := v.(type) {
-	case Any:
-		// avoid reserializing the type if we have an any.
-		return t, nil
-	case proto.Message:
-		marshal = func(v interface{}) ([]byte, error) {
-			return proto.Marshal(t)
-		}
-	case gogoproto.Message:
-		marshal = func(v interface{}) ([]byte, error) {
-			return gogoproto.Marshal(t)
-		}
-	default:
-		marshal = json.Marshal
-	}
+	return DefaultRegistry.MarshalAny(v)
+}
+
+// MustMarshalAny marshals the value v into an any in the same way as
+// MarshalAny, panicking if it cannot be marshaled. It is intended for tests and
+// for initializing package level variables.
+func MustMarshalAny(v interface{}) Any {
+	return DefaultRegistry.MustMarshalAny(v)
+}
+
+// MarshalAnyCopy marshals the value v into an any in the same way as
+// MarshalAny, except that an Any provided as v is copied with Clone rather
+// than returned verbatim, so the result never shares its value with v.
+func MarshalAnyCopy(v interface{}) (Any, error) {
+	return DefaultRegistry.MarshalAnyCopy(v)
+}
+
+// MarshalAnyPB marshals the value v in the same way as MarshalAny, returning
+// a google.golang.org/protobuf Any for APIs which require one. See ToProto.
+func MarshalAnyPB(v interface{}) (*anypb.Any, error) {
+	return DefaultRegistry.MarshalAnyPB(v)
+}
+
+// MarshalAnyGogo marshals the value v in the same way as MarshalAny, returning
+// a github.com/gogo/protobuf Any for APIs which require one. See ToGogo.
+func MarshalAnyGogo(v interface{}) (*gogotypes.Any, error) {
+	return DefaultRegistry.MarshalAnyGogo(v)
+}
+
+// MarshalAnyDeterministic marshals the value v into an any in the same way as
+// MarshalAny, but produces identical bytes for equal values.
+//
+// Protocol buffer messages are marshaled with deterministic map ordering.
+// This output is stable for a given binary, but may change between versions
+// of the protobuf libraries. Messages using gogo/protobuf are not covered, as
+// its generated marshalers do not support deterministic output.
+//
+// All other types are marshaled to json, ignoring the default codec, which
+// orders map keys and struct fields consistently. Types implementing
+// json.Marshaler are only as stable as their implementation.
+func MarshalAnyDeterministic(v interface{}) (Any, error) {
+	return DefaultRegistry.MarshalAnyDeterministic(v)
+}
+
+// MarshalAnyProto marshals the value v into an any in the same way as
+// MarshalAny, but returns an error instead of falling back to the default
+// codec when v is not a protocol buffer message. Types implementing
+// AnyMarshaler are rejected too, as their encoding is up to the type.
+func MarshalAnyProto(v interface{}) (Any, error) {
+	return DefaultRegistry.MarshalAnyProto(v)
+}
 
-	url, err := TypeURL(v)
-	if err != nil {
-		return nil, err
+// MarshalAnyJSON marshals the value v into an any in the same way as
+// MarshalAny, but encodes protocol buffer messages as their canonical JSON
+// mapping, with camelCase field names, for consumers reading values as JSON.
+// Their type url carries a "+json" suffix so that UnmarshalAny decodes them
+// from JSON. All other types are marshaled to json, ignoring the default codec.
+func MarshalAnyJSON(v interface{}) (Any, error) {
+	return DefaultRegistry.MarshalAnyJSON(v)
+}
+
+// MarshalAnyWith marshals the value v into an any in the same way as
+// MarshalAny, but encodes types which are not protocol buffer messages with
+// codec instead of the default codec. The codec name is recorded on the type
+// url, so codec must be made available with RegisterCodec wherever the value
+// is unmarshaled.
+func MarshalAnyWith(v interface{}, codec Codec) (Any, error) {
+	return DefaultRegistry.MarshalAnyWith(v, codec)
+}
+
+// MarshalSize returns the length of the value of the any MarshalAny would
+// return for v, such as to enforce a maximum message size. Protocol buffer
+// messages are sized without being marshaled. Other types are encoded with the
+// default codec to measure them, so the encoding is allocated but discarded.
+// Nil values, which MarshalAny marshals to an empty value, have a size of 0.
+func MarshalSize(v interface{}) (int, error) {
+	return DefaultRegistry.MarshalSize(v)
+}
+
+// MarshalAnySlice marshals each value in vs into an any in the same way as
+// MarshalAny. The type url of each distinct type is only looked up once,
+// making it cheaper than calling MarshalAny repeatedly for values of the same
+// type. The error for the first value failing to marshal includes its index.
+func MarshalAnySlice(vs []interface{}) ([]Any, error) {
+	return DefaultRegistry.MarshalAnySlice(vs)
+}
+
+// UnmarshalAny unmarshals the any type into a concrete type. A nil Any, with
+// neither a type url nor a value, unmarshals to nil, while an Any with a type
+// url and an empty value unmarshals to the zero value of the type.
+func UnmarshalAny(any Any) (interface{}, error) {
+	return DefaultRegistry.UnmarshalAny(any)
+}
+
+// ResolveAny unmarshals the any type into a concrete type in the same way as
+// UnmarshalAny, also returning the type the type url resolved to. As with
+// TypeOf, the type is never a pointer type; the value is a pointer to it. A
+// nil Any returns a nil value and type.
+func ResolveAny(any Any) (interface{}, reflect.Type, error) {
+	return DefaultRegistry.ResolveAny(any)
+}
+
+// UnknownValue holds an Any whose type url could not be resolved, as returned
+// by UnmarshalAnyTolerant. It implements Any, so MarshalAny returns it
+// verbatim, allowing values of unknown types to be passed through unchanged.
+type UnknownValue struct {
+	TypeURL string
+	Value   []byte
+}
+
+// GetTypeUrl returns the type url of the value.
+func (u *UnknownValue) GetTypeUrl() string {
+	if u == nil {
+		return ""
 	}
+	return u.TypeURL
+}
 
-	data, err := marshal(v)
-	if err != nil {
-		return nil, err
+// GetValue returns the encoded value.
+func (u *UnknownValue) GetValue() []byte {
+	if u == nil {
+		return nil
 	}
-	return &anyType{
-		typeURL: url,
-		value:   data,
-	}, nil
+	return u.Value
 }
 
-// UnmarshalAny unmarshals the any type into a concrete type.
-func UnmarshalAny(any Any) (interface{}, error) {
-	return UnmarshalByTypeURL(any.GetTypeUrl(), any.GetValue())
+// UnmarshalAnyTolerant unmarshals the any type into a concrete type in the
+// same way as UnmarshalAny, except that an Any with a type url which cannot be
+// resolved is returned as an *UnknownValue rather than an error, so that it
+// can be forwarded. Errors decoding values of known types are still returned.
+func UnmarshalAnyTolerant(any Any) (interface{}, error) {
+	return DefaultRegistry.UnmarshalAnyTolerant(any)
+}
+
+// Validate checks that any can be unmarshaled, returning the error
+// UnmarshalAny would return. The type url must resolve to a registered type or
+// a known protocol buffer message, and the value must decode as that type.
+// The decoded value is discarded.
+func Validate(any Any) error {
+	return DefaultRegistry.Validate(any)
+}
+
+// UnmarshalAnySlice unmarshals each any in anys into a concrete type in the
+// same way as UnmarshalAny. Nil elements unmarshal to nil. The error for the
+// first element failing to unmarshal includes its index.
+func UnmarshalAnySlice(anys []Any) ([]interface{}, error) {
+	return DefaultRegistry.UnmarshalAnySlice(anys)
+}
+
+// UnmarshalToFromPool unmarshals the any type into a concrete type in the same
+// way as UnmarshalAny, but rather than allocating the output, it is obtained by
+// calling get with the resolved type. get must return a pointer to the type,
+// such as one taken from a sync.Pool. The instance should be reset before it
+// is returned, as codecs like json merge into existing values.
+func UnmarshalToFromPool(any Any, get func(reflect.Type) interface{}) (interface{}, error) {
+	return DefaultRegistry.UnmarshalToFromPool(any, get)
 }
 
 // UnmarshalByTypeURL unmarshals the given type and value to into a concrete type.
 func UnmarshalByTypeURL(typeURL string, value []byte) (interface{}, error) {
-	return unmarshal(typeURL, value, nil)
+	return DefaultRegistry.UnmarshalByTypeURL(typeURL, value)
 }
 
 // UnmarshalTo unmarshals the any type into a concrete type passed in the out
 // argument. It is identical to UnmarshalAny, but lets clients provide a
 // destination type through the out argument.
+//
+// Fields unknown to the destination, such as those added by a newer version
+// of a message, do not cause an error. Protocol buffer messages retain them
+// when decoded from the binary encoding, and discard them when decoded from
+// JSON.
+//
+// When out is a pointer to an interface, the concrete type is resolved from
+// the type url, as with UnmarshalAny, and assigned to the interface. A
+// *TypeMismatchError is returned if the concrete type, which is always a
+// pointer, does not implement the interface.
 func UnmarshalTo(any Any, out interface{}) error {
-	return UnmarshalToByTypeURL(any.GetTypeUrl(), any.GetValue(), out)
+	return DefaultRegistry.UnmarshalTo(any, out)
+}
+
+// UnmarshalToFunc unmarshals the any type into the output returned by newOut,
+// for outputs which need more than allocation to construct. It is identical
+// to UnmarshalTo, but newOut is only called once the type url has been
+// resolved, and not at all for a nil Any. A *TypeMismatchError is returned if
+// the output does not match the type url.
+func UnmarshalToFunc(any Any, newOut func() interface{}) error {
+	return DefaultRegistry.UnmarshalToFunc(any, newOut)
 }
 
 // UnmarshalToByTypeURL unmarshals the given type and value into a concrete type passed
 // in the out argument. It is identical to UnmarshalByTypeURL, but lets clients
 // provide a destination type through the out argument.
+//
+// Reusing out across calls avoids allocating an output for each value, such as
+// when replaying a log of values of the same type. As with UnmarshalTo, a
+// *TypeMismatchError is returned when typeURL does not match the type of out.
 func UnmarshalToByTypeURL(typeURL string, value []byte, out interface{}) error {
-	_, err := unmarshal(typeURL, value, out)
-	return err
-}
-
-func unmarshal(typeURL string, value []byte, v interface{}) (interface{}, error) {
-	if value == nil {
-		return nil, nil
-	}
-
-	t, err := getTypeByUrl(typeURL)
-	if err != nil {
-		return nil, err
-	}
-
-	if v == nil {
-		v = reflect.New(t.t).Interface()
-	} else {
-		// Validate interface type provided by client
-		vURL, err := TypeURL(v)
-		if err != nil {
-			return nil, err
-		}
-		if typeURL != vURL {
-			return nil, fmt.Errorf("can't unmarshal type %q to output %q", typeURL, vURL)
-		}
-	}
-
-	if t.isProto {
-		switch t := v.(type) {
-		case proto.Message:
-			err = proto.Unmarshal(value, t)
-		case gogoproto.Message:
-			err = gogoproto.Unmarshal(value, t)
-		}
-	} else {
-		err = json.Unmarshal(value, v)
-	}
-
-	return v, err
-}
-
-type urlType struct {
-	t       reflect.Type
-	isProto bool
+	return DefaultRegistry.UnmarshalToByTypeURL(typeURL, value, out)
 }
 
-func getTypeByUrl(url string) (urlType, error) {
-	mu.RLock()
-	for t, u := range registry {
-		if u == url {
-			mu.RUnlock()
-			return urlType{
-				t: t,
-			}, nil
-		}
-	}
-	mu.RUnlock()
-	// fallback to proto registry
-	t := gogoproto.MessageType(url)
-	if t != nil {
-		return urlType{
-			// get the underlying Elem because proto returns a pointer to the type
-			t:       t.Elem(),
-			isProto: true,
-		}, nil
-	}
-	mt, err := protoregistry.GlobalTypes.FindMessageByURL(url)
-	if err != nil {
-		return urlType{}, fmt.Errorf("type with url %s: %w", url, ErrNotFound)
+// isProtoURL returns true if url refers to the protocol buffer message v
+// using a prefixed url, such as type.googleapis.com/google.protobuf.Timestamp.
+func isProtoURL(url string, v interface{}) bool {
+	var name string
+	switch t := v.(type) {
+	case proto.Message:
+		name = string(t.ProtoReflect().Descriptor().FullName())
+	case gogoproto.Message:
+		name = gogoproto.MessageName(t)
+	default:
+		return false
 	}
-	empty := mt.New().Interface()
-	return urlType{t: reflect.TypeOf(empty).Elem(), isProto: true}, nil
+	return strings.HasSuffix(url, "/"+name)
 }
 
 func tryDereference(v interface{}) reflect.Type {
 	t := reflect.TypeOf(v)
 	if t.Kind() == reflect.Ptr {
-		// require check of pointer but dereference to register
-		return t.Elem()
+		// require check of pointer but dereference to register, through
+		// any number of pointers.
+		for t.Kind() == reflect.Ptr {
+			t = t.Elem()
+		}
+		return t
 	}
 	panic("v is not a pointer to a type")
 }
@@ -18,13 +18,27 @@ package typeurl
 
 import (
 	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
 	"github.com/gogo/protobuf/proto"
 	gogotypes "github.com/gogo/protobuf/types"
+	"google.golang.org/protobuf/types/descriptorpb"
 	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+	"google.golang.org/protobuf/types/known/structpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
@@ -34,7 +48,7 @@ type test struct {
 }
 
 func clear() {
-	registry = make(map[reflect.Type]string)
+	DefaultRegistry = NewRegistry()
 }
 
 var _ Any = &gogotypes.Any{}
@@ -63,19 +77,13 @@ func TestMarshal(t *testing.T) {
 		Name: "koye",
 		Age:  6,
 	}
-	any, err := MarshalAny(v)
-	if err != nil {
-		t.Fatal(err)
-	}
+	any := MustMarshalAny(v)
 	if any.GetTypeUrl() != expected {
 		t.Fatalf("expected %q but received %q", expected, any.GetTypeUrl())
 	}
 
 	// marshal it again and make sure we get the same thing back.
-	newany, err := MarshalAny(any)
-	if err != nil {
-		t.Fatal(err)
-	}
+	newany := MustMarshalAny(any)
 
 	val := any.GetValue()
 	newval := newany.GetValue()
@@ -89,6 +97,26 @@ func TestMarshal(t *testing.T) {
 
 }
 
+func TestMarshalCopy(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	any := MustMarshalAny(&test{Name: "koye", Age: 6})
+	newany, err := MarshalAnyCopy(any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Equal(newany, any) {
+		t.Fatalf("expected copy to equal original: %v != %v", newany, any)
+	}
+
+	// Ensure the copy does not share the slice
+	newany.GetValue()[0] ^= 0xff
+	if Equal(newany, any) {
+		t.Fatal("modifying the copy modified the original")
+	}
+}
+
 func TestMarshalUnmarshal(t *testing.T) {
 	clear()
 	Register(&test{}, "test")
@@ -97,10 +125,7 @@ func TestMarshalUnmarshal(t *testing.T) {
 		Name: "koye",
 		Age:  6,
 	}
-	any, err := MarshalAny(v)
-	if err != nil {
-		t.Fatal(err)
-	}
+	any := MustMarshalAny(v)
 	nv, err := UnmarshalAny(any)
 	if err != nil {
 		t.Fatal(err)
@@ -125,12 +150,9 @@ func TestMarshalUnmarshalTo(t *testing.T) {
 		Name: "koye",
 		Age:  6,
 	}
-	any, err := MarshalAny(in)
-	if err != nil {
-		t.Fatal(err)
-	}
+	any := MustMarshalAny(in)
 	out := &test{}
-	err = UnmarshalTo(any, out)
+	err := UnmarshalTo(any, out)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -155,16 +177,20 @@ func TestUnmarshalToInvalid(t *testing.T) {
 		Name: "koye",
 		Age:  6,
 	}
-	any, err := MarshalAny(in)
-	if err != nil {
-		t.Fatal(err)
-	}
+	any := MustMarshalAny(in)
 
 	out := &test2{}
-	err = UnmarshalTo(any, out)
+	err := UnmarshalTo(any, out)
 	if err == nil || err.Error() != `can't unmarshal type "test1" to output "test2"` {
 		t.Fatalf("unexpected result: %+v", err)
 	}
+	var mismatch *TypeMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected a *TypeMismatchError, got %T", err)
+	}
+	if mismatch.Have != "test1" || mismatch.Want != "test2" {
+		t.Fatalf("unexpected mismatch %+v", mismatch)
+	}
 }
 
 func TestIs(t *testing.T) {
@@ -175,15 +201,29 @@ func TestIs(t *testing.T) {
 		Name: "koye",
 		Age:  6,
 	}
-	any, err := MarshalAny(v)
-	if err != nil {
-		t.Fatal(err)
-	}
+	any := MustMarshalAny(v)
 	if !Is(any, &test{}) {
 		t.Fatal("Is(any, test{}) should be true")
 	}
 }
 
+func TestRegisterSameURL(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	defer func() {
+		err, _ := recover().(error)
+		if err == nil {
+			t.Fatal("registering different types with the same url should panic")
+		}
+		for _, name := range []string{"typeurl.test", "typeurl.test2"} {
+			if !strings.Contains(err.Error(), name) {
+				t.Fatalf("expected %q to name %s", err, name)
+			}
+		}
+	}()
+	Register(&test2{}, "test")
+}
+
 func TestRegisterDiffUrls(t *testing.T) {
 	clear()
 	defer func() {
@@ -216,6 +256,82 @@ func TestUnmarshalNil(t *testing.T) {
 	}
 }
 
+func TestMarshalNil(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	Register(&timestamppb.Timestamp{}, "types.example.com/Timestamp")
+
+	for _, v := range []interface{}{nil, (*anypb.Any)(nil), (*anyType)(nil)} {
+		any, err := MarshalAny(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if any != nil {
+			t.Fatalf("expected a nil any for %#v, got %v", v, any)
+		}
+	}
+
+	for _, tc := range []struct {
+		v   interface{}
+		url string
+	}{
+		{(*test)(nil), "test"},
+		{(*timestamppb.Timestamp)(nil), "types.example.com/Timestamp"},
+		{(*gogotypes.Duration)(nil), "google.protobuf.Duration"},
+	} {
+		any, err := MarshalAny(tc.v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if any.GetTypeUrl() != tc.url || len(any.GetValue()) != 0 {
+			t.Fatalf("expected an empty value of type %q, got %q %v", tc.url, any.GetTypeUrl(), any.GetValue())
+		}
+		v, err := UnmarshalAny(any)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reflect.TypeOf(v) != reflect.TypeOf(tc.v) {
+			t.Fatalf("expected %T, got %T", tc.v, v)
+		}
+	}
+
+	if _, err := MarshalAny((*test2)(nil)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unregistered type, got %v", err)
+	}
+
+	for name, marshal := range map[string]func(interface{}) (Any, error){
+		"MarshalAnyChecksummed": MarshalAnyChecksummed,
+		"MarshalAnyCompressed": func(v interface{}) (Any, error) {
+			return MarshalAnyCompressed(v, 0)
+		},
+	} {
+		if any, err := marshal(nil); any != nil || err != nil {
+			t.Fatalf("%s: expected a nil any, got %v: %v", name, any, err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := MarshalAnyTo(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := UnmarshalAnyFrom(&buf); err != nil || v != nil {
+		t.Fatalf("expected nil to read back as nil, got %v: %v", v, err)
+	}
+
+	for _, v := range []interface{}{nil, (*anypb.Any)(nil), (*test)(nil)} {
+		size, err := MarshalSize(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size != 0 {
+			t.Fatalf("expected a size of 0 for %#v, got %d", v, size)
+		}
+	}
+	if _, err := MarshalSize((*test2)(nil)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unregistered type, got %v", err)
+	}
+}
+
 func TestCheckNil(t *testing.T) {
 	var a *anyType
 
@@ -243,3 +359,2302 @@ func TestProtoFallback(t *testing.T) {
 		t.Fatalf("expected %+v but got %+v", expected, ts.AsTime())
 	}
 }
+
+func TestUnregister(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	if !Unregister(&test{}) {
+		t.Fatal("expected registered type to be removed")
+	}
+	if _, err := TypeURL(&test{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after unregister, got %v", err)
+	}
+	if Unregister(&test{}) {
+		t.Fatal("unregistering an unknown type should report false")
+	}
+
+	// the type can be registered again, even under a different url.
+	Register(&test{}, "test", "two")
+	url, err := TypeURL(&test{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "test/two" {
+		t.Fatalf("expected %q but received %q", "test/two", url)
+	}
+}
+
+func TestRegistered(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	Register(&test2{}, "test2")
+
+	m := Registered()
+	expected := map[string]reflect.Type{
+		"test":  reflect.TypeOf(test{}),
+		"test2": reflect.TypeOf(test2{}),
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("expected %v but received %v", expected, m)
+	}
+
+	// mutating the snapshot must not affect the registry.
+	delete(m, "test")
+	if _, err := TypeURL(&test{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRegisterConcurrent(t *testing.T) {
+	clear()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			Register(&test{}, "test")
+		}()
+		go func() {
+			defer wg.Done()
+			TypeURL(&test{})
+			Registered()
+		}()
+	}
+	wg.Wait()
+
+	url, err := TypeURL(&test{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "test" {
+		t.Fatalf("expected %q but received %q", "test", url)
+	}
+}
+
+func TestGenericMarshalUnmarshal(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	any, err := Marshal(&test{Name: "koye", Age: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	td, err := Unmarshal[*test](any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if td.Name != "koye" {
+		t.Fatal("invalid name")
+	}
+	if td.Age != 6 {
+		t.Fatal("invalid age")
+	}
+}
+
+func TestGenericUnmarshalWrongType(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	any, err := Marshal(&test{Name: "koye", Age: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Unmarshal[*test2](any)
+	if err == nil || err.Error() != "unmarshaled type *typeurl.test is not *typeurl.test2" {
+		t.Fatalf("unexpected result: %+v", err)
+	}
+}
+
+type xmlCodec struct{}
+
+func (xmlCodec) Name() string                               { return "xml" }
+func (xmlCodec) Marshal(v interface{}) ([]byte, error)      { return xml.Marshal(v) }
+func (xmlCodec) Unmarshal(data []byte, v interface{}) error { return xml.Unmarshal(data, v) }
+
+// gobCodec is a binary codec, standing in for codecs such as msgpack.
+type gobCodec struct{}
+
+func (gobCodec) Name() string { return "gob" }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+func TestDefaultCodec(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	jsonAny := MustMarshalAny(&test{Name: "koye", Age: 6})
+
+	SetDefaultCodec(xmlCodec{})
+	defer SetDefaultCodec(JSONCodec{})
+
+	any := MustMarshalAny(&test{Name: "koye", Age: 6})
+	if any.GetTypeUrl() != "test+xml" {
+		t.Fatalf("expected %q but received %q", "test+xml", any.GetTypeUrl())
+	}
+	if !bytes.HasPrefix(any.GetValue(), []byte("<test>")) {
+		t.Fatalf("expected xml encoded value, got %q", any.GetValue())
+	}
+	if !Is(any, &test{}) {
+		t.Fatal("Is(any, test{}) should be true")
+	}
+
+	// values encoded with either codec must round trip.
+	for _, a := range []Any{any, jsonAny} {
+		nv, err := UnmarshalAny(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		td, ok := nv.(*test)
+		if !ok {
+			t.Fatal("expected value to cast to *test")
+		}
+		if td.Name != "koye" || td.Age != 6 {
+			t.Fatalf("unexpected value %+v", td)
+		}
+	}
+}
+
+func TestBinaryCodec(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	SetDefaultCodec(gobCodec{})
+	any, err := MarshalAny(&test{Name: "koye", Age: 6})
+	SetDefaultCodec(JSONCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if any.GetTypeUrl() != "test+gob" {
+		t.Fatalf("expected %q but received %q", "test+gob", any.GetTypeUrl())
+	}
+
+	// the codec stays registered for decoding after the default is reset.
+	nv, err := UnmarshalAny(any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if td := nv.(*test); td.Name != "koye" || td.Age != 6 {
+		t.Fatalf("unexpected value %+v", td)
+	}
+
+	// existing json encoded values still decode.
+	nv, err = UnmarshalByTypeURL("test", []byte(`{"Name":"koye","Age":6}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if td := nv.(*test); td.Name != "koye" || td.Age != 6 {
+		t.Fatalf("unexpected value %+v", td)
+	}
+}
+
+func TestErrNotFound(t *testing.T) {
+	clear()
+
+	if _, err := TypeURL(&test{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected TypeURL to return ErrNotFound, got %v", err)
+	}
+	if _, err := MarshalAny(&test{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected MarshalAny to return ErrNotFound, got %v", err)
+	}
+	if _, err := UnmarshalByTypeURL("test", []byte("{}")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected UnmarshalByTypeURL to return ErrNotFound, got %v", err)
+	}
+}
+
+func TestRegisterType(t *testing.T) {
+	clear()
+	RegisterType(reflect.TypeOf(&test{}), "test")
+	RegisterType(reflect.TypeOf(test2{}), "test2")
+
+	for expected, v := range map[string]interface{}{
+		"test":  &test{},
+		"test2": &test2{},
+	} {
+		url, err := TypeURL(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if url != expected {
+			t.Fatalf("expected %q but received %q", expected, url)
+		}
+	}
+
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("registering the same type with different urls should panic")
+		}
+	}()
+	RegisterType(reflect.TypeOf(test{}), "test", "two")
+}
+
+func TestLookupTypeURL(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	url, ok := LookupTypeURL(&test{})
+	if !ok || url != "test" {
+		t.Fatalf("expected %q but received %q (found %v)", "test", url, ok)
+	}
+	if url, ok := LookupTypeURL(&test2{}); ok {
+		t.Fatalf("expected unregistered type to not be found, got %q", url)
+	}
+	v := &test2{}
+	if allocs := testing.AllocsPerRun(100, func() { LookupTypeURL(v) }); allocs != 0 {
+		t.Fatalf("expected no allocations on miss, got %v", allocs)
+	}
+}
+
+type testMap struct {
+	Values map[string]int
+}
+
+func TestMarshalAnyDeterministic(t *testing.T) {
+	clear()
+	Register(&testMap{}, "testmap")
+
+	SetDefaultCodec(xmlCodec{})
+	defer SetDefaultCodec(JSONCodec{})
+
+	v := &testMap{Values: map[string]int{}}
+	for i := 0; i < 64; i++ {
+		v.Values[strconv.Itoa(i)] = i
+	}
+	expected, err := MarshalAnyDeterministic(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected.GetTypeUrl() != "testmap" {
+		t.Fatalf("expected json encoding, got url %q", expected.GetTypeUrl())
+	}
+	for i := 0; i < 8; i++ {
+		any, err := MarshalAnyDeterministic(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(any.GetValue(), expected.GetValue()) {
+			t.Fatalf("expected identical bytes: %q != %q", any.GetValue(), expected.GetValue())
+		}
+	}
+
+	for _, m := range []interface{}{
+		&structpb.Struct{Fields: map[string]*structpb.Value{
+			"a": structpb.NewStringValue("a"),
+			"b": structpb.NewStringValue("b"),
+			"c": structpb.NewStringValue("c"),
+		}},
+		timestamppb.Now(),
+	} {
+		first, err := MarshalAnyDeterministic(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		second, err := MarshalAnyDeterministic(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(first.GetValue(), second.GetValue()) {
+			t.Fatalf("expected identical bytes for %T", m)
+		}
+	}
+}
+
+func TestProtoUnmarshalTo(t *testing.T) {
+	expected := time.Now()
+	b, err := proto.Marshal(timestamppb.New(expected))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, url := range []string{
+		"type.googleapis.com/google.protobuf.Timestamp",
+		"google.protobuf.Timestamp",
+	} {
+		ts := &timestamppb.Timestamp{}
+		if err := UnmarshalTo(&anypb.Any{TypeUrl: url, Value: b}, ts); err != nil {
+			t.Fatal(err)
+		}
+		if expected.Sub(ts.AsTime()) != 0 {
+			t.Fatalf("expected %+v but got %+v", expected, ts.AsTime())
+		}
+	}
+}
+
+func TestRegisteredProtoMarshalUnmarshal(t *testing.T) {
+	clear()
+	Register(&timestamppb.Timestamp{}, "timestamp")
+
+	expected := timestamppb.Now()
+	any := MustMarshalAny(expected)
+	if any.GetTypeUrl() != "timestamp" {
+		t.Fatalf("expected %q but received %q", "timestamp", any.GetTypeUrl())
+	}
+	v, err := UnmarshalAny(any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts, ok := v.(*timestamppb.Timestamp)
+	if !ok {
+		t.Fatalf("failed to convert %+v to Timestamp", v)
+	}
+	if !ts.AsTime().Equal(expected.AsTime()) {
+		t.Fatalf("expected %+v but got %+v", expected.AsTime(), ts.AsTime())
+	}
+}
+
+func TestRegisterAlias(t *testing.T) {
+	clear()
+	Register(&test{}, "test", "v2")
+	RegisterAlias(&test{}, "test", "v1")
+
+	urls := URLsFor(&test{})
+	if expected := []string{"test/v2", "test/v1"}; !reflect.DeepEqual(urls, expected) {
+		t.Fatalf("expected %v but received %v", expected, urls)
+	}
+	if urls := URLsFor(&test2{}); urls != nil {
+		t.Fatalf("expected no urls for unregistered type, got %v", urls)
+	}
+
+	// the registered url remains the primary one.
+	url, err := TypeURL(&test{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "test/v2" {
+		t.Fatalf("expected %q but received %q", "test/v2", url)
+	}
+
+	legacy := &anypb.Any{TypeUrl: "test/v1", Value: []byte(`{"Name":"koye","Age":6}`)}
+	if !Is(legacy, &test{}) {
+		t.Fatal("Is(legacy, test{}) should be true")
+	}
+	out := &test{}
+	if err := UnmarshalTo(legacy, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Name != "koye" || out.Age != 6 {
+		t.Fatalf("unexpected value %+v", out)
+	}
+}
+
+type thing struct {
+	Name string
+}
+
+func TestUnmarshalAlias(t *testing.T) {
+	clear()
+	Register(&thing{}, "foo.v2.Thing")
+	RegisterAlias(&thing{}, "foo.v1.Thing")
+	Register(&test{}, "foo.v3.Thing")
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("aliasing a url registered to another type should panic")
+			}
+		}()
+		RegisterAlias(&test{}, "foo.v1.Thing")
+	}()
+
+	for _, url := range []string{"foo.v1.Thing", "foo.v2.Thing"} {
+		for i := 0; i < 8; i++ {
+			v, err := UnmarshalByTypeURL(url, []byte(`{"Name":"koye"}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			th, ok := v.(*thing)
+			if !ok {
+				t.Fatalf("expected %q to resolve to *thing, got %T", url, v)
+			}
+			if th.Name != "koye" {
+				t.Fatal("invalid name")
+			}
+		}
+	}
+
+	Unregister(&thing{})
+	if _, err := UnmarshalByTypeURL("foo.v2.Thing", []byte(`{}`)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after unregister, got %v", err)
+	}
+}
+
+func TestMarshalAnyProto(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	if _, err := MarshalAnyProto(&test{}); err == nil || err.Error() != "type *typeurl.test is not a protocol buffer message" {
+		t.Fatalf("unexpected result: %+v", err)
+	}
+	if _, err := MarshalAnyProto(&point{X: 1, Y: 2}); err == nil {
+		t.Fatal("expected an error for a type implementing AnyMarshaler")
+	}
+	for _, v := range []interface{}{timestamppb.Now(), &gogotypes.Timestamp{Seconds: 1}} {
+		any, err := MarshalAnyProto(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if any.GetTypeUrl() != "google.protobuf.Timestamp" {
+			t.Fatalf("unexpected url %q for %T", any.GetTypeUrl(), v)
+		}
+	}
+}
+
+func TestMarshalUnmarshalCtx(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	any, err := MarshalAnyCtx(ctx, &test{Name: "koye", Age: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := UnmarshalAnyCtx(ctx, any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if td := v.(*test); td.Name != "koye" || td.Age != 6 {
+		t.Fatalf("unexpected value %+v", td)
+	}
+
+	cancel()
+	if _, err := MarshalAnyCtx(ctx, &test{}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if _, err := UnmarshalAnyCtx(ctx, any); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestContentType(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	RegisterCodec(gobCodec{})
+	RegisterCodec(xmlCodec{})
+
+	for _, testcase := range []struct {
+		typeURL  string
+		expected string
+	}{
+		{typeURL: "test", expected: "application/json"},
+		{typeURL: "test+" + xmlCodec{}.Name(), expected: "application/octet-stream"},
+		{typeURL: "type.googleapis.com/google.protobuf.Timestamp", expected: "application/x-protobuf"},
+		{typeURL: "type.googleapis.com/google.protobuf.Timestamp+json", expected: "application/json"},
+		{typeURL: "unknown", expected: "application/octet-stream"},
+	} {
+		ct := ContentType(&anypb.Any{TypeUrl: testcase.typeURL})
+		if ct != testcase.expected {
+			t.Fatalf("expected %q for %q, got %q", testcase.expected, testcase.typeURL, ct)
+		}
+	}
+	for _, any := range []Any{nil, (*anypb.Any)(nil)} {
+		if ct := ContentType(any); ct != "application/octet-stream" {
+			t.Fatalf("expected application/octet-stream for %#v, got %q", any, ct)
+		}
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	RegisterCodec(gobCodec{})
+
+	ts, err := proto.Marshal(timestamppb.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, testcase := range []struct {
+		any      Any
+		expected string
+	}{
+		{
+			any:      &anypb.Any{TypeUrl: "test", Value: []byte("{}")},
+			expected: "json",
+		},
+		{
+			any:      &anypb.Any{TypeUrl: "test+gob", Value: []byte{0x80}},
+			expected: "gob",
+		},
+		{
+			any:      &anypb.Any{TypeUrl: "type.googleapis.com/google.protobuf.Timestamp", Value: ts},
+			expected: EncodingProtobuf,
+		},
+	} {
+		encoding, err := Encoding(testcase.any)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if encoding != testcase.expected {
+			t.Fatalf("expected %q for %q, got %q", testcase.expected, testcase.any.GetTypeUrl(), encoding)
+		}
+	}
+
+	for _, any := range []Any{nil, (*anypb.Any)(nil)} {
+		if encoding, err := Encoding(any); err == nil || encoding != EncodingUnknown {
+			t.Fatalf("expected %q and an error for %#v, got %q: %v", EncodingUnknown, any, encoding, err)
+		}
+	}
+
+	encoding, err := Encoding(&anypb.Any{TypeUrl: "unknown"})
+	if !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if encoding != EncodingUnknown {
+		t.Fatalf("expected %q, got %q", EncodingUnknown, encoding)
+	}
+}
+
+func TestIsType(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	any := MustMarshalAny(&test{Name: "koye", Age: 6})
+	if !IsType(any, reflect.TypeOf(test{})) {
+		t.Fatal("IsType(any, test) should be true")
+	}
+	if !IsType(any, reflect.TypeOf(&test{})) {
+		t.Fatal("IsType(any, *test) should be true")
+	}
+	if IsType(any, reflect.TypeOf(test2{})) {
+		t.Fatal("IsType(any, test2) should be false")
+	}
+	if IsType(nil, reflect.TypeOf(test{})) {
+		t.Fatal("IsType(nil, test) should be false")
+	}
+	var pba *anypb.Any
+	if IsType(pba, reflect.TypeOf(test{})) {
+		t.Fatal("IsType(typed nil, test) should be false")
+	}
+}
+
+func TestMarshalAnySlice(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	Register(&test2{}, "test2")
+
+	vs := []interface{}{
+		&test{Name: "koye", Age: 6},
+		&test2{Name: "kitty"},
+		&test{Name: "bob", Age: 1},
+	}
+	anys, err := MarshalAnySlice(vs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(anys) != len(vs) {
+		t.Fatalf("expected %d anys, got %d", len(vs), len(anys))
+	}
+	for i, any := range anys {
+		v, err := UnmarshalAny(any)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(v, vs[i]) {
+			t.Fatalf("round trip failed %v != %v", v, vs[i])
+		}
+	}
+
+	_, err = MarshalAnySlice(append(vs, &testMap{}))
+	if !errors.Is(err, ErrNotFound) || !strings.HasPrefix(err.Error(), "index 3: ") {
+		t.Fatalf("unexpected result: %+v", err)
+	}
+}
+
+func BenchmarkMarshalAnySlice(b *testing.B) {
+	clear()
+	Register(&test{}, "test")
+
+	vs := make([]interface{}, 10000)
+	for i := range vs {
+		vs[i] = &test{Name: "koye", Age: i}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := MarshalAnySlice(vs); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestRegisterDefaultURL(t *testing.T) {
+	clear()
+	Register(&test{})
+	Register(&timestamppb.Timestamp{})
+
+	for expected, v := range map[string]interface{}{
+		"github.com/containerd/typeurl/v2.test": &test{},
+		"google.protobuf.Timestamp":             &timestamppb.Timestamp{},
+	} {
+		url, err := TypeURL(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if url != expected {
+			t.Fatalf("expected %q but received %q", expected, url)
+		}
+	}
+
+	SetDefaultURLFunc(func(t reflect.Type) string {
+		return "types.example.com/" + t.Name()
+	})
+	defer SetDefaultURLFunc(DefaultURL)
+
+	RegisterType(reflect.TypeOf(test2{}))
+	url, err := TypeURL(&test2{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "types.example.com/test2" {
+		t.Fatalf("expected %q but received %q", "types.example.com/test2", url)
+	}
+}
+
+func TestUnmarshalAnySlice(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	Register(&test2{}, "test2")
+
+	vs := []interface{}{
+		&test{Name: "koye", Age: 6},
+		&test2{Name: "kitty"},
+	}
+	anys, err := MarshalAnySlice(vs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var pba *anypb.Any
+	anys = append(anys, nil, pba)
+	vs = append(vs, nil, nil)
+
+	actual, err := UnmarshalAnySlice(anys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(actual, vs) {
+		t.Fatalf("round trip failed %v != %v", actual, vs)
+	}
+
+	_, err = UnmarshalAnySlice(append(anys, &anypb.Any{TypeUrl: "unknown", Value: []byte("{}")}))
+	if !errors.Is(err, ErrNotFound) || !strings.HasPrefix(err.Error(), "index 4: ") {
+		t.Fatalf("unexpected result: %+v", err)
+	}
+}
+
+type panicCodec struct{}
+
+func (panicCodec) Name() string                               { return "panic" }
+func (panicCodec) Marshal(v interface{}) ([]byte, error)      { return []byte{}, nil }
+func (panicCodec) Unmarshal(data []byte, v interface{}) error { panic("malformed") }
+
+func TestUnmarshalRecoversPanic(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	RegisterCodec(panicCodec{})
+
+	_, err := UnmarshalByTypeURL("test+panic", []byte("value"))
+	if err == nil || err.Error() != `failed to unmarshal type "test": malformed` {
+		t.Fatalf("unexpected result: %+v", err)
+	}
+}
+
+func FuzzUnmarshalByTypeURL(f *testing.F) {
+	clear()
+	Register(&test{}, "test")
+
+	ts, err := proto.Marshal(timestamppb.Now())
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add("test", []byte(`{"Name":"koye","Age":6}`))
+	f.Add("type.googleapis.com/google.protobuf.Timestamp", ts)
+	f.Add("google.protobuf.Timestamp", ts)
+	f.Add("google.protobuf.Any", []byte{0x0a, 0x01})
+	f.Fuzz(func(t *testing.T, typeURL string, value []byte) {
+		// only a clean return, in error or success, is expected.
+		UnmarshalByTypeURL(typeURL, value)
+	})
+}
+
+func TestMarshalAnyCompressed(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	small := &test{Name: "koye", Age: 6}
+	any, err := MarshalAnyCompressed(small, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if any.GetTypeUrl() != "test" {
+		t.Fatalf("expected small value to be stored uncompressed, got url %q", any.GetTypeUrl())
+	}
+
+	large := &test{Name: strings.Repeat("koye", 1024), Age: 6}
+	any, err = MarshalAnyCompressed(large, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if any.GetTypeUrl() != "test+gzip" {
+		t.Fatalf("expected %q but received %q", "test+gzip", any.GetTypeUrl())
+	}
+	if len(any.GetValue()) >= len(large.Name) {
+		t.Fatalf("expected compressed value, got %d bytes", len(any.GetValue()))
+	}
+	if !Is(any, &test{}) {
+		t.Fatal("Is(any, test{}) should be true")
+	}
+
+	v, err := UnmarshalAny(any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, large) {
+		t.Fatal("round trip of compressed value failed")
+	}
+
+	ts := timestamppb.Now()
+	any, err = MarshalAnyCompressed(ts, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &timestamppb.Timestamp{}
+	if err := UnmarshalTo(any, out); err != nil {
+		t.Fatal(err)
+	}
+	if !out.AsTime().Equal(ts.AsTime()) {
+		t.Fatal("round trip of compressed proto message failed")
+	}
+
+	checksummed, err := MarshalAnyChecksummed(large)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// an Any is not compressed again, keeping its checksum valid.
+	if any, err = MarshalAnyCompressed(checksummed, 0); err != nil || any != checksummed {
+		t.Fatalf("expected the Any to be returned unchanged, got %v, %v", any, err)
+	}
+}
+
+func TestMustTypeURL(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	if url := MustTypeURL(&test{}); url != "test" {
+		t.Fatalf("expected %q but received %q", "test", url)
+	}
+
+	defer func() {
+		if err := recover(); err == nil {
+			t.Error("MustTypeURL of an unregistered type should panic")
+		}
+	}()
+	MustTypeURL(&test2{})
+}
+
+func TestValidate(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	any := MustMarshalAny(&test{Name: "koye", Age: 6})
+	if err := Validate(any); err != nil {
+		t.Fatal(err)
+	}
+	if err := Validate(&anypb.Any{TypeUrl: "unknown", Value: []byte("{}")}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if err := Validate(&anypb.Any{TypeUrl: "test", Value: []byte("{")}); err == nil {
+		t.Fatal("expected malformed value to fail validation")
+	}
+}
+
+func TestUnmarshalToFromPool(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	pool := sync.Pool{New: func() interface{} { return &test{} }}
+	get := func(t reflect.Type) interface{} {
+		if t == reflect.TypeOf(test{}) {
+			return pool.Get()
+		}
+		return reflect.New(t).Interface()
+	}
+
+	pooled := &test{}
+	pool.Put(pooled)
+
+	any := MustMarshalAny(&test{Name: "koye", Age: 6})
+	v, err := UnmarshalToFromPool(any, get)
+	if err != nil {
+		t.Fatal(err)
+	}
+	td := v.(*test)
+	if td.Name != "koye" || td.Age != 6 {
+		t.Fatalf("unexpected value %+v", td)
+	}
+
+	_, err = UnmarshalToFromPool(any, func(reflect.Type) interface{} { return &test2{} })
+	if err == nil || err.Error() != `pool returned *typeurl.test2 for type "test", expected *typeurl.test` {
+		t.Fatalf("unexpected result: %+v", err)
+	}
+}
+
+func TestTypeOf(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	for url, expected := range map[string]reflect.Type{
+		"test":      reflect.TypeOf(test{}),
+		"test+gzip": reflect.TypeOf(test{}),
+		"type.googleapis.com/google.protobuf.Timestamp": reflect.TypeOf(timestamppb.Timestamp{}),
+	} {
+		actual, ok := TypeOf(url)
+		if !ok {
+			t.Fatalf("expected %q to resolve", url)
+		}
+		if actual != expected {
+			t.Fatalf("expected %q to resolve to %s, got %s", url, expected, actual)
+		}
+	}
+	if actual, ok := TypeOf("unknown"); ok {
+		t.Fatalf("expected unknown url to not resolve, got %s", actual)
+	}
+}
+
+func TestMarshalAnyChecksummed(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	v := &test{Name: "koye", Age: 6}
+	any, err := MarshalAnyChecksummed(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(any.GetTypeUrl(), "test?crc32c=") {
+		t.Fatalf("expected checksum in url, got %q", any.GetTypeUrl())
+	}
+	if !Is(any, &test{}) {
+		t.Fatal("Is(any, test{}) should be true")
+	}
+	nv, err := UnmarshalAny(any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(nv, v) {
+		t.Fatalf("round trip failed %v != %v", nv, v)
+	}
+
+	corrupt := append([]byte{}, any.GetValue()...)
+	corrupt[len(corrupt)-2] ^= 0x01
+	_, err = UnmarshalAny(&anypb.Any{TypeUrl: any.GetTypeUrl(), Value: corrupt})
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+
+	// an Any is checksummed rather than passed through unchanged.
+	passed, err := MarshalAnyChecksummed(MustMarshalAny(v))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if passed.GetTypeUrl() != any.GetTypeUrl() {
+		t.Fatalf("expected %q, got %q", any.GetTypeUrl(), passed.GetTypeUrl())
+	}
+}
+
+// point encodes itself as two bytes rather than through a codec.
+type point struct {
+	X, Y byte
+}
+
+func (p *point) MarshalTypeURL() (string, []byte, error) {
+	return "point", []byte{p.X, p.Y}, nil
+}
+
+func (p *point) UnmarshalTypeURL(b []byte) error {
+	if len(b) != 2 {
+		return fmt.Errorf("invalid point of %d bytes", len(b))
+	}
+	p.X, p.Y = b[0], b[1]
+	return nil
+}
+
+func TestAnyMarshaler(t *testing.T) {
+	clear()
+	Register(&point{}, "point")
+
+	in := &point{X: 1, Y: 2}
+	any := MustMarshalAny(in)
+	if any.GetTypeUrl() != "point" || !bytes.Equal(any.GetValue(), []byte{1, 2}) {
+		t.Fatalf("unexpected any %q %v", any.GetTypeUrl(), any.GetValue())
+	}
+
+	v, err := UnmarshalAny(any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, in) {
+		t.Fatalf("round trip failed %v != %v", v, in)
+	}
+
+	out := &point{}
+	if err := UnmarshalTo(any, out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(out, in) {
+		t.Fatalf("round trip failed %v != %v", out, in)
+	}
+
+	if err := UnmarshalTo(&anypb.Any{TypeUrl: "point", Value: []byte{1}}, out); err == nil {
+		t.Fatal("expected UnmarshalTypeURL error to be returned")
+	}
+}
+
+func TestProtoTypeURL(t *testing.T) {
+	clear()
+
+	for expected, v := range map[string]interface{}{
+		"google.protobuf.Timestamp": &timestamppb.Timestamp{},
+		"google.protobuf.Duration":  &gogotypes.Duration{},
+	} {
+		url, err := TypeURL(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if url != expected {
+			t.Fatalf("expected %q but received %q", expected, url)
+		}
+	}
+
+	r := NewRegistry()
+	r.SetURLPrefix("type.googleapis.com/")
+	if url := r.MustTypeURL(&timestamppb.Timestamp{}); url != "type.googleapis.com/google.protobuf.Timestamp" {
+		t.Fatalf("expected the prefixed url, got %q", url)
+	}
+
+	Register(&timestamppb.Timestamp{}, "types.example.com/Timestamp")
+	any := MustMarshalAny(timestamppb.Now())
+	if any.GetTypeUrl() != "types.example.com/Timestamp" {
+		t.Fatalf("expected registered url to take precedence, got %q", any.GetTypeUrl())
+	}
+}
+
+func TestUnmarshalToByTypeURLReuse(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	Register(&test2{}, "test2")
+
+	out := &test{}
+	for _, name := range []string{"koye", "mikan"} {
+		*out = test{}
+		if err := UnmarshalToByTypeURL("test", []byte(`{"Name":"`+name+`"}`), out); err != nil {
+			t.Fatal(err)
+		}
+		if out.Name != name {
+			t.Fatalf("expected %q, got %q", name, out.Name)
+		}
+	}
+
+	err := UnmarshalToByTypeURL("test2", []byte(`{}`), out)
+	var mismatch *TypeMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected a *TypeMismatchError, got %v", err)
+	}
+	if mismatch.Have != "test2" || mismatch.Want != "test" {
+		t.Fatalf("unexpected mismatch %+v", mismatch)
+	}
+}
+
+func TestRegisterAnonymous(t *testing.T) {
+	clear()
+
+	v := &struct{ Name string }{}
+	func() {
+		defer func() {
+			err, _ := recover().(error)
+			if err == nil || !strings.Contains(err.Error(), "explicit url") {
+				t.Fatalf("expected a panic asking for an explicit url, got %v", err)
+			}
+		}()
+		Register(v)
+	}()
+
+	Register(v, "types.example.com/anonymous")
+	any := MustMarshalAny(&struct{ Name string }{Name: "koye"})
+	if any.GetTypeUrl() != "types.example.com/anonymous" {
+		t.Fatalf("unexpected url %q", any.GetTypeUrl())
+	}
+	out, err := UnmarshalAny(any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.(*struct{ Name string }).Name != "koye" {
+		t.Fatalf("unexpected value %+v", out)
+	}
+}
+
+func TestMustMarshalAny(t *testing.T) {
+	clear()
+	defer func() {
+		if err, _ := recover().(error); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected a panic with ErrNotFound, got %v", err)
+		}
+	}()
+	MustMarshalAny(&test{})
+}
+
+func TestMarshalAnyJSON(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	in := &structpb.Struct{Fields: map[string]*structpb.Value{
+		"name": structpb.NewStringValue("koye"),
+	}}
+	any, err := MarshalAnyJSON(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if any.GetTypeUrl() != "google.protobuf.Struct+json" {
+		t.Fatalf("unexpected url %q", any.GetTypeUrl())
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(any.GetValue(), &m); err != nil {
+		t.Fatalf("expected a JSON value, got %q: %v", any.GetValue(), err)
+	}
+	if enc, err := Encoding(any); err != nil || enc != "json" {
+		t.Fatalf("expected json encoding, got %q: %v", enc, err)
+	}
+	out := &structpb.Struct{}
+	if err := UnmarshalTo(any, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Fields["name"].GetStringValue() != "koye" {
+		t.Fatalf("unexpected value %v", out)
+	}
+
+	// field names follow the proto JSON mapping.
+	any, err = MarshalAnyJSON(&descriptorpb.FieldDescriptorProto{TypeName: proto.String("Foo")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m = nil
+	if err := json.Unmarshal(any.GetValue(), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m["typeName"] != "Foo" {
+		t.Fatalf("expected camelCase field names, got %s", any.GetValue())
+	}
+
+	// as do gogo/protobuf messages.
+	any, err = MarshalAnyJSON(&gogotypes.Duration{Seconds: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(any.GetValue()) != `"1s"` {
+		t.Fatalf("unexpected value %s", any.GetValue())
+	}
+	v, err := UnmarshalAny(any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.(*gogotypes.Duration).Seconds != 1 {
+		t.Fatalf("unexpected value %v", v)
+	}
+
+	any, err = MarshalAnyJSON(&test{Name: "koye", Age: 6})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if any.GetTypeUrl() != "test" || string(any.GetValue()) != `{"Name":"koye","Age":6}` {
+		t.Fatalf("unexpected any %q %s", any.GetTypeUrl(), any.GetValue())
+	}
+}
+
+func TestMarshalAnyWith(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	RegisterCodec(gobCodec{})
+
+	for codec, expected := range map[Codec]string{
+		gobCodec{}:  "test+gob",
+		JSONCodec{}: "test",
+	} {
+		any, err := MarshalAnyWith(&test{Name: "koye", Age: 6}, codec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if any.GetTypeUrl() != expected {
+			t.Fatalf("expected %q but received %q", expected, any.GetTypeUrl())
+		}
+		nv, err := UnmarshalAny(any)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if td := nv.(*test); td.Name != "koye" || td.Age != 6 {
+			t.Fatalf("unexpected value %+v", td)
+		}
+	}
+
+	// protocol buffer messages are unaffected by the codec.
+	any, err := MarshalAnyWith(timestamppb.Now(), gobCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if any.GetTypeUrl() != "google.protobuf.Timestamp" {
+		t.Fatalf("unexpected url %q", any.GetTypeUrl())
+	}
+}
+
+func TestSameType(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	RegisterAlias(&test{}, "legacy.test")
+	Register(&test2{}, "test2")
+	RegisterCodec(gobCodec{})
+
+	a := MustMarshalAny(&test{Name: "koye"})
+	for _, tc := range []struct {
+		b        Any
+		expected bool
+	}{
+		{MustMarshalAny(&test{Name: "mikan", Age: 6}), true},
+		{&anypb.Any{TypeUrl: "legacy.test"}, true},
+		{&anypb.Any{TypeUrl: "test+gob"}, true},
+		{MustMarshalAny(&test2{}), false},
+		{&anypb.Any{TypeUrl: "unknown"}, false},
+		{nil, false},
+	} {
+		if SameType(a, tc.b) != tc.expected {
+			t.Errorf("SameType(%q, %v) should be %v", a.GetTypeUrl(), tc.b, tc.expected)
+		}
+	}
+}
+
+func TestUnmarshalEmptyValue(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	for _, any := range []Any{
+		&anypb.Any{TypeUrl: "test"},
+		&anypb.Any{TypeUrl: "test", Value: []byte{}},
+		&anypb.Any{TypeUrl: "test+gzip"},
+	} {
+		v, err := UnmarshalAny(any)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if td, ok := v.(*test); !ok || *td != (test{}) {
+			t.Fatalf("expected a zero *test, got %#v", v)
+		}
+	}
+
+	v, err := UnmarshalAny(&anypb.Any{TypeUrl: "google.protobuf.Duration"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d, ok := v.(*gogotypes.Duration); !ok || d.Seconds != 0 {
+		t.Fatalf("expected a zero duration, got %#v", v)
+	}
+
+	if v, err := UnmarshalAny(&anypb.Any{}); err != nil || v != nil {
+		t.Fatalf("expected nil for an empty Any, got %v: %v", v, err)
+	}
+	if _, err := UnmarshalAny(&anypb.Any{TypeUrl: "unknown"}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMarshalContainerTypes(t *testing.T) {
+	clear()
+	Register(&map[string]int{}, "types.example.com/counts")
+	Register(&[]string{}, "types.example.com/names")
+
+	counts := map[string]int{"koye": 6}
+	any := MustMarshalAny(&counts)
+	if any.GetTypeUrl() != "types.example.com/counts" {
+		t.Fatalf("unexpected url %q", any.GetTypeUrl())
+	}
+	v, err := UnmarshalAny(any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, ok := v.(*map[string]int)
+	if !ok || !reflect.DeepEqual(*m, counts) {
+		t.Fatalf("expected %v, got %#v", counts, v)
+	}
+
+	names := []string{"koye", "mikan"}
+	any = MustMarshalAny(&names)
+	v, err = UnmarshalAny(any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, ok := v.(*[]string)
+	if !ok || !reflect.DeepEqual(*s, names) {
+		t.Fatalf("expected %v, got %#v", names, v)
+	}
+	if rt, _ := TypeOf("types.example.com/names"); rt != reflect.TypeOf(names) {
+		t.Fatalf("unexpected type %v", rt)
+	}
+}
+
+func TestUnmarshalUnknownFields(t *testing.T) {
+	clear()
+
+	// field 99, varint 1, is unknown to both messages.
+	unknown := []byte{0x98, 0x06, 0x01}
+	value := append([]byte{0x08, 0x01}, unknown...)
+
+	out := &timestamppb.Timestamp{}
+	if err := UnmarshalTo(&anypb.Any{TypeUrl: "google.protobuf.Timestamp", Value: value}, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Seconds != 1 {
+		t.Fatalf("unexpected value %v", out)
+	}
+	gout := &gogotypes.Duration{}
+	if err := UnmarshalTo(&anypb.Any{TypeUrl: "google.protobuf.Duration", Value: value}, gout); err != nil {
+		t.Fatal(err)
+	}
+	if gout.Seconds != 1 {
+		t.Fatalf("unexpected value %v", gout)
+	}
+
+	fdout := &descriptorpb.FieldDescriptorProto{}
+	if err := UnmarshalTo(&anypb.Any{TypeUrl: "google.protobuf.FieldDescriptorProto+json", Value: []byte(`{"typeName":"Foo","extra":true}`)}, fdout); err != nil {
+		t.Fatal(err)
+	}
+	if fdout.GetTypeName() != "Foo" {
+		t.Fatalf("unexpected value %v", fdout)
+	}
+	aout := &gogotypes.Api{}
+	if err := UnmarshalTo(&anypb.Any{TypeUrl: "google.protobuf.Api+json", Value: []byte(`{"name":"koye","extra":1}`)}, aout); err != nil {
+		t.Fatal(err)
+	}
+	if aout.Name != "koye" {
+		t.Fatalf("unexpected value %v", aout)
+	}
+}
+
+func TestResolveAny(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	v, rt, err := ResolveAny(MustMarshalAny(&test{Name: "koye", Age: 6}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rt != reflect.TypeOf(test{}) {
+		t.Fatalf("unexpected type %v", rt)
+	}
+	if td := v.(*test); td.Name != "koye" || td.Age != 6 {
+		t.Fatalf("unexpected value %+v", td)
+	}
+
+	if v, rt, err := ResolveAny(&anypb.Any{}); v != nil || rt != nil || err != nil {
+		t.Fatalf("expected nil results for a nil Any, got %v %v %v", v, rt, err)
+	}
+	if _, _, err := ResolveAny(&anypb.Any{TypeUrl: "unknown", Value: []byte("{}")}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSprint(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	s := Sprint(MustMarshalAny(&test{Name: "koye", Age: 6}))
+	if s != "test {\n  \"Name\": \"koye\",\n  \"Age\": 6\n}" {
+		t.Fatalf("unexpected output %q", s)
+	}
+	s = Sprint(MustMarshalAny(&gogotypes.Duration{Seconds: 1}))
+	if s != `google.protobuf.Duration "1s"` {
+		t.Fatalf("unexpected output %q", s)
+	}
+
+	for any, expected := range map[Any]string{
+		&anypb.Any{TypeUrl: "test", Value: []byte("{")}:     "test ew==",
+		&anypb.Any{TypeUrl: "unknown", Value: []byte{0xff}}: "unknown /w==",
+		(*anypb.Any)(nil): "<nil>",
+	} {
+		if s := Sprint(any); s != expected {
+			t.Fatalf("expected %q, got %q", expected, s)
+		}
+	}
+
+	if s := SprintN(MustMarshalAny(&test{Name: strings.Repeat("a", 100)}), 10); s != "test {\n  \"..." {
+		t.Fatalf("unexpected truncated output %q", s)
+	}
+	// the limit falls within the first "é", which is dropped whole.
+	if s := SprintN(MustMarshalAny(&test{Name: "éé"}), 19); s != "test {\n  \"Name\": \"..." {
+		t.Fatalf("unexpected truncated output %q", s)
+	}
+}
+
+type shape interface {
+	Area() int
+}
+
+type square struct {
+	Side int
+}
+
+func (s *square) Area() int { return s.Side * s.Side }
+
+type rect struct {
+	Width, Height int
+}
+
+func (r *rect) Area() int { return r.Width * r.Height }
+
+func TestUnmarshalToInterface(t *testing.T) {
+	clear()
+	Register(&square{}, "square")
+	Register(&rect{}, "rect")
+	Register(&test{}, "test")
+
+	for _, in := range []shape{&square{Side: 2}, &rect{Width: 2, Height: 3}} {
+		var out shape
+		if err := UnmarshalTo(MustMarshalAny(in), &out); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, in) {
+			t.Fatalf("expected %#v, got %#v", in, out)
+		}
+	}
+
+	var out shape
+	err := UnmarshalTo(MustMarshalAny(&test{}), &out)
+	var mismatch *TypeMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected a *TypeMismatchError, got %v", err)
+	}
+	if mismatch.Have != "test" || mismatch.Want != "typeurl.shape" {
+		t.Fatalf("unexpected mismatch %+v", mismatch)
+	}
+	if out != nil {
+		t.Fatalf("expected out to be unchanged, got %v", out)
+	}
+}
+
+func TestMarshalUnmarshalTime(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	now := time.Now().UTC()
+	any, err := MarshalTime(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if any.GetTypeUrl() != "google.protobuf.Timestamp" {
+		t.Fatalf("unexpected url %q", any.GetTypeUrl())
+	}
+	for _, a := range []Any{any, ToGogo(any)} {
+		out, err := UnmarshalTime(a)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !out.Equal(now) {
+			t.Fatalf("expected %v, got %v", now, out)
+		}
+	}
+
+	// the google message is used when it is registered.
+	Register(&timestamppb.Timestamp{}, "google.protobuf.Timestamp")
+	if out, err := UnmarshalTime(any); err != nil || !out.Equal(now) {
+		t.Fatalf("expected %v, got %v: %v", now, out, err)
+	}
+
+	if _, err := UnmarshalTime(MustMarshalAny(&test{})); err == nil {
+		t.Fatal("expected an error for a value which is not a timestamp")
+	}
+	if _, err := MarshalTime(time.Time{}.Add(-time.Hour)); err == nil {
+		t.Fatal("expected an error for a time outside the timestamp range")
+	}
+}
+
+func TestMarshalSize(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	for _, v := range []interface{}{
+		&test{Name: "koye", Age: 6},
+		timestamppb.Now(),
+		&gogotypes.Duration{Seconds: 100},
+		&point{X: 1, Y: 2},
+		&anypb.Any{TypeUrl: "test", Value: []byte("{}")},
+	} {
+		size, err := MarshalSize(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := len(MustMarshalAny(v).GetValue()); size != expected {
+			t.Fatalf("expected size %d for %T, got %d", expected, v, size)
+		}
+	}
+
+	if _, err := MarshalSize(&test2{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSetURLPrefix(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	SetURLPrefix("types.example.com")
+
+	for expected, v := range map[string]interface{}{
+		"types.example.com/google.protobuf.Timestamp": timestamppb.Now(),
+		"types.example.com/google.protobuf.Duration":  &gogotypes.Duration{Seconds: 1},
+		"test": &test{},
+	} {
+		any := MustMarshalAny(v)
+		if any.GetTypeUrl() != expected {
+			t.Fatalf("expected %q but received %q", expected, any.GetTypeUrl())
+		}
+		if _, err := UnmarshalAny(any); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// values marshaled with another prefix still resolve.
+	d := &gogotypes.Duration{}
+	if err := UnmarshalToByTypeURL("type.googleapis.com/google.protobuf.Duration", []byte{0x08, 0x01}, d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Seconds != 1 {
+		t.Fatalf("unexpected value %v", d)
+	}
+
+	SetURLPrefix("")
+	if url := MustTypeURL(timestamppb.Now()); url != "google.protobuf.Timestamp" {
+		t.Fatalf("unexpected url %q", url)
+	}
+}
+
+func TestMergeAny(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	base := MustMarshalAny(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"a": structpb.NewStringValue("a"),
+		"b": structpb.NewStringValue("b"),
+	}})
+	patch := MustMarshalAny(&structpb.Struct{Fields: map[string]*structpb.Value{
+		"b": structpb.NewStringValue("patched"),
+	}})
+	merged, err := MergeAny(base, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &structpb.Struct{}
+	if err := UnmarshalTo(merged, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Fields["a"].GetStringValue() != "a" || out.Fields["b"].GetStringValue() != "patched" {
+		t.Fatalf("unexpected merge result %v", out)
+	}
+
+	merged, err = MergeAny(MustMarshalAny(&gogotypes.Duration{Seconds: 1}), MustMarshalAny(&gogotypes.Duration{Nanos: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := UnmarshalAny(merged)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d := d.(*gogotypes.Duration); d.Seconds != 1 || d.Nanos != 2 {
+		t.Fatalf("unexpected merge result %v", d)
+	}
+
+	fd := &descriptorpb.FileDescriptorProto{Name: proto.String("base.proto")}
+	merged, err = MergeAny(Cached(MustMarshalAny(fd), fd), MustMarshalAny(&descriptorpb.FileDescriptorProto{Package: proto.String("patched")}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fd.Package != nil {
+		t.Fatalf("the cached value of base should not be modified, got %v", fd)
+	}
+	if v, err := UnmarshalAny(merged); err != nil || v.(*descriptorpb.FileDescriptorProto).GetPackage() != "patched" {
+		t.Fatalf("unexpected merge result %v: %v", v, err)
+	}
+
+	if _, err := MergeAny(base, MustMarshalAny(timestamppb.Now())); err == nil {
+		t.Fatal("expected an error merging different types")
+	}
+	if _, err := MergeAny(MustMarshalAny(&test{}), MustMarshalAny(&test{})); err == nil {
+		t.Fatal("expected an error merging types which are not protocol buffer messages")
+	}
+}
+
+func TestValueEqual(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	Register(&test2{}, "test2")
+
+	for _, tc := range []struct {
+		a, b     Any
+		expected bool
+	}{
+		// equivalent json with differing field order.
+		{&anypb.Any{TypeUrl: "test", Value: []byte(`{"Name":"koye","Age":6}`)}, &anypb.Any{TypeUrl: "test", Value: []byte(`{"Age":6,"Name":"koye"}`)}, true},
+		{MustMarshalAny(&test{Name: "koye"}), MustMarshalAny(&test{Name: "mikan"}), false},
+		{MustMarshalAny(&test{}), MustMarshalAny(&test2{}), false},
+		{&anypb.Any{TypeUrl: "google.protobuf.Timestamp", Value: []byte{0x08, 0x01}}, MustMarshalAny(&timestamppb.Timestamp{Seconds: 1}), true},
+		{MustMarshalAny(&gogotypes.Duration{Seconds: 1}), MustMarshalAny(&gogotypes.Duration{Seconds: 2}), false},
+		{nil, (*anypb.Any)(nil), true},
+		{nil, MustMarshalAny(&test{}), false},
+	} {
+		equal, err := ValueEqual(tc.a, tc.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if equal != tc.expected {
+			t.Errorf("ValueEqual(%v, %v) should be %v", tc.a, tc.b, tc.expected)
+		}
+	}
+
+	if _, err := ValueEqual(&anypb.Any{TypeUrl: "test", Value: []byte("{")}, MustMarshalAny(&test{})); err == nil {
+		t.Fatal("expected an error for a malformed value")
+	}
+}
+
+func TestUnmarshalAnyTolerant(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	v, err := UnmarshalAnyTolerant(MustMarshalAny(&test{Name: "koye"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.(*test).Name != "koye" {
+		t.Fatalf("unexpected value %+v", v)
+	}
+
+	in := &anypb.Any{TypeUrl: "types.example.com/unknown", Value: []byte("value")}
+	v, err = UnmarshalAnyTolerant(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, ok := v.(*UnknownValue)
+	if !ok || u.TypeURL != in.TypeUrl || string(u.Value) != "value" {
+		t.Fatalf("expected an unknown value, got %#v", v)
+	}
+	// unknown values are forwarded unchanged.
+	if out := MustMarshalAny(u); !Equal(out, in) {
+		t.Fatalf("expected %v, got %v", in, out)
+	}
+
+	if _, err := UnmarshalAnyTolerant(&anypb.Any{TypeUrl: "test", Value: []byte("{")}); err == nil {
+		t.Fatal("expected an error for a malformed value of a known type")
+	}
+}
+
+func TestElementTypeURL(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	for expected, slice := range map[string]interface{}{
+		"test":                      []*test{{Name: "koye"}},
+		"google.protobuf.Timestamp": []*timestamppb.Timestamp(nil),
+	} {
+		url, err := ElementTypeURL(slice)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if url != expected {
+			t.Fatalf("expected %q but received %q", expected, url)
+		}
+	}
+	if url, err := ElementTypeURL([]test{}); err != nil || url != "test" {
+		t.Fatalf("expected %q, got %q: %v", "test", url, err)
+	}
+
+	if _, err := ElementTypeURL([]*test2{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if _, err := ElementTypeURL(&test{}); err == nil {
+		t.Fatal("expected an error for a value which is not a slice")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	for _, v := range []interface{}{
+		&test{Name: "koye", Age: 6},
+		&gogotypes.Duration{Seconds: 1},
+	} {
+		out, err := RoundTrip(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(out, v) {
+			t.Fatalf("expected %v, got %v", v, out)
+		}
+	}
+
+	// the result has the type of v, even where the type url resolves to the
+	// gogo/protobuf message.
+	out, err := RoundTrip(&timestamppb.Timestamp{Seconds: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts, ok := out.(*timestamppb.Timestamp); !ok || ts.Seconds != 1 {
+		t.Fatalf("unexpected value %#v", out)
+	}
+	if _, err := RoundTrip(&test2{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+type configV1 struct {
+	Name string
+}
+
+type configV2 struct {
+	Names []string
+}
+
+func TestRegisterVersioned(t *testing.T) {
+	clear()
+	RegisterVersioned(&configV1{}, "types.example.com/config", 1)
+	RegisterVersioned(&configV2{}, "types.example.com/config", 2)
+
+	v1 := MustMarshalAny(&configV1{Name: "koye"})
+	if v1.GetTypeUrl() != "types.example.com/config?v=1" {
+		t.Fatalf("unexpected url %q", v1.GetTypeUrl())
+	}
+	v, err := UnmarshalAny(v1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := v.(*configV1); !ok || c.Name != "koye" {
+		t.Fatalf("unexpected value %#v", v)
+	}
+
+	// the version survives the encoding recorded on the url.
+	RegisterCodec(gobCodec{})
+	v2, err := MarshalAnyWith(&configV2{Names: []string{"koye"}}, gobCodec{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v2.GetTypeUrl() != "types.example.com/config+gob?v=2" {
+		t.Fatalf("unexpected url %q", v2.GetTypeUrl())
+	}
+	out := &configV2{}
+	if err := UnmarshalTo(v2, out); err != nil {
+		t.Fatal(err)
+	}
+	if len(out.Names) != 1 {
+		t.Fatalf("unexpected value %+v", out)
+	}
+	if err := UnmarshalTo(v1, out); err == nil {
+		t.Fatal("expected an error unmarshaling version 1 to version 2")
+	}
+
+	// values without a version decode to the latest version.
+	v, err = UnmarshalByTypeURL("types.example.com/config", []byte(`{"Names":["koye"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := v.(*configV2); !ok {
+		t.Fatalf("expected the latest version, got %T", v)
+	}
+	if _, err := UnmarshalByTypeURL("types.example.com/config?v=3", []byte(`{}`)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for an unknown version, got %v", err)
+	}
+
+	Unregister(&configV2{})
+	v, err = UnmarshalByTypeURL("types.example.com/config", []byte(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := v.(*configV1); !ok {
+		t.Fatalf("expected version 1 after unregistering version 2, got %T", v)
+	}
+}
+
+func TestAnyJSON(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	Register(&[]string{}, "names")
+
+	for _, tc := range []struct {
+		v        interface{}
+		expected map[string]interface{}
+	}{
+		{&test{Name: "koye", Age: 6}, map[string]interface{}{"@type": "test", "Name": "koye", "Age": 6.0}},
+		{&[]string{"koye"}, map[string]interface{}{"@type": "names", "value": []interface{}{"koye"}}},
+		{&descriptorpb.FieldDescriptorProto{TypeName: proto.String("Foo")}, map[string]interface{}{"@type": "google.protobuf.FieldDescriptorProto", "typeName": "Foo"}},
+		{&gogotypes.Duration{Seconds: 1}, map[string]interface{}{"@type": "google.protobuf.Duration", "value": "1s"}},
+	} {
+		in := MustMarshalAny(tc.v)
+		doc, err := json.Marshal(struct {
+			Event Any `json:"event"`
+		}{in})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var decoded struct {
+			Event map[string]interface{} `json:"event"`
+		}
+		if err := json.Unmarshal(doc, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(decoded.Event, tc.expected) {
+			t.Fatalf("expected %v, got %s", tc.expected, doc)
+		}
+
+		var decodedAny struct {
+			Event *anyType `json:"event"`
+		}
+		if err := json.Unmarshal(doc, &decodedAny); err != nil {
+			t.Fatal(err)
+		}
+		if equal, err := ValueEqual(decodedAny.Event, in); err != nil || !equal {
+			t.Fatalf("expected %s to round trip, got %v: %v", doc, decodedAny.Event, err)
+		}
+	}
+
+	var a anyType
+	if err := json.Unmarshal([]byte(`{"Name":"koye"}`), &a); err == nil {
+		t.Fatal("expected an error for a missing @type")
+	}
+	if data, err := json.Marshal(New("", nil)); err != nil || string(data) != "null" {
+		t.Fatalf("expected an empty any to encode as null, got %s: %v", data, err)
+	}
+	if _, err := json.Marshal(&anyType{typeURL: "unknown", value: []byte{1}}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	state := Snapshot()
+	Unregister(&test{})
+	Register(&test{}, "other")
+	Register(&test2{}, "test2")
+	SetURLPrefix("types.example.com")
+
+	Restore(state)
+	if url := MustTypeURL(&test{}); url != "test" {
+		t.Fatalf("expected %q, got %q", "test", url)
+	}
+	if _, ok := LookupTypeURL(&test2{}); ok {
+		t.Fatal("test2 should not be registered after restoring")
+	}
+	if url := MustTypeURL(timestamppb.Now()); url != "google.protobuf.Timestamp" {
+		t.Fatalf("expected the url prefix to be restored, got %q", url)
+	}
+
+	// the snapshot is unaffected by later registrations.
+	Register(&test2{}, "test2")
+	Restore(state)
+	if _, ok := LookupTypeURL(&test2{}); ok {
+		t.Fatal("test2 should not be registered after restoring again")
+	}
+}
+
+func TestIsUnregisteredProto(t *testing.T) {
+	clear()
+
+	any := MustMarshalAny(timestamppb.Now())
+	if !Is(any, &timestamppb.Timestamp{}) {
+		t.Fatal("Is(any, timestamppb.Timestamp{}) should be true")
+	}
+	if !Is(&anypb.Any{TypeUrl: "type.googleapis.com/google.protobuf.Timestamp"}, &timestamppb.Timestamp{}) {
+		t.Fatal("Is should match a prefixed url")
+	}
+	if !Is(MustMarshalAny(&gogotypes.Duration{}), &gogotypes.Duration{}) {
+		t.Fatal("Is(any, gogotypes.Duration{}) should be true")
+	}
+	if Is(any, &gogotypes.Duration{}) {
+		t.Fatal("Is(any, gogotypes.Duration{}) should be false for a timestamp")
+	}
+}
+
+type codeError struct {
+	Code    int
+	Message string
+}
+
+func (e *codeError) Error() string {
+	return fmt.Sprintf("%d: %s", e.Code, e.Message)
+}
+
+// valueError is an error which is not a pointer.
+type valueError string
+
+func (e valueError) Error() string { return string(e) }
+
+func TestMarshalUnmarshalError(t *testing.T) {
+	clear()
+	Register(&codeError{}, "codeError")
+	Register(&test{}, "test")
+
+	any, err := MarshalError(&codeError{Code: 404, Message: "not found"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if any.GetTypeUrl() != "codeError" {
+		t.Fatalf("unexpected url %q", any.GetTypeUrl())
+	}
+	var ce *codeError
+	if err := UnmarshalError(any); !errors.As(err, &ce) || ce.Code != 404 {
+		t.Fatalf("expected a *codeError, got %#v", err)
+	}
+
+	any, err = MarshalError(fmt.Errorf("wrapped: %w", io.EOF))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if any.GetTypeUrl() != ErrorURL {
+		t.Fatalf("unexpected url %q", any.GetTypeUrl())
+	}
+	if err := UnmarshalError(any); err == nil || err.Error() != "wrapped: EOF" {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	for _, err := range []error{syscall.ENOENT, valueError("value error")} {
+		any, merr := MarshalError(err)
+		if merr != nil {
+			t.Fatal(merr)
+		}
+		if any.GetTypeUrl() != ErrorURL {
+			t.Fatalf("unexpected url %q for %T", any.GetTypeUrl(), err)
+		}
+		if uerr := UnmarshalError(any); uerr == nil || uerr.Error() != err.Error() {
+			t.Fatalf("expected %q, got %v", err, uerr)
+		}
+	}
+
+	if any, err := MarshalError(nil); any != nil || err != nil {
+		t.Fatalf("expected nil for a nil error, got %v: %v", any, err)
+	}
+	if err := UnmarshalError(nil); err != nil {
+		t.Fatalf("expected nil for a nil Any, got %v", err)
+	}
+	if err := UnmarshalError(MustMarshalAny(&test{})); err == nil || !strings.Contains(err.Error(), "not an error") {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestUnmarshalToFunc(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	Register(&test2{}, "test2")
+
+	var out *test
+	newOut := func() interface{} {
+		out = &test{Age: -1}
+		return out
+	}
+	if err := UnmarshalToFunc(&anypb.Any{TypeUrl: "test", Value: []byte(`{"Name":"koye"}`)}, newOut); err != nil {
+		t.Fatal(err)
+	}
+	// json merges into the constructed output.
+	if out.Name != "koye" || out.Age != -1 {
+		t.Fatalf("unexpected value %+v", out)
+	}
+
+	err := UnmarshalToFunc(MustMarshalAny(&test2{}), newOut)
+	var mismatch *TypeMismatchError
+	if !errors.As(err, &mismatch) {
+		t.Fatalf("expected a *TypeMismatchError, got %v", err)
+	}
+
+	out = nil
+	if err := UnmarshalToFunc(&anypb.Any{}, newOut); err != nil {
+		t.Fatal(err)
+	}
+	if err := UnmarshalToFunc(&anypb.Any{TypeUrl: "unknown", Value: []byte("{}")}, newOut); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if out != nil {
+		t.Fatal("newOut should not be called for a nil or unresolved Any")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+	Register(&test2{}, "test2")
+
+	for _, tc := range []struct {
+		a, b     Any
+		expected string
+	}{
+		{MustMarshalAny(&test{Name: "koye", Age: 6}), MustMarshalAny(&test{Name: "koye", Age: 6}), ""},
+		{MustMarshalAny(&test{Name: "koye", Age: 6}), MustMarshalAny(&test{Name: "mikan", Age: 7}), "Age: 6 != 7\nName: \"koye\" != \"mikan\""},
+		{MustMarshalAny(&test{}), MustMarshalAny(&test2{}), `different type URLs: "test" != "test2"`},
+		{
+			MustMarshalAny(&structpb.Struct{Fields: map[string]*structpb.Value{"a": structpb.NewStringValue("a")}}),
+			MustMarshalAny(&structpb.Struct{Fields: map[string]*structpb.Value{"b": structpb.NewListValue(&structpb.ListValue{})}}),
+			"a: \"a\" != null\nb: null != []",
+		},
+		{MustMarshalAny(&gogotypes.Duration{Seconds: 1}), MustMarshalAny(&gogotypes.Duration{Seconds: 2}), `value: "1s" != "2s"`},
+		{nil, MustMarshalAny(&test{}), `different type URLs: <nil> != "test"`},
+		{MustMarshalAny(&test{}), (*anypb.Any)(nil), `different type URLs: "test" != <nil>`},
+		{nil, (*anypb.Any)(nil), ""},
+	} {
+		diff, err := Diff(tc.a, tc.b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if diff != tc.expected {
+			t.Errorf("expected diff %q, got %q", tc.expected, diff)
+		}
+	}
+}
+
+func TestRegistryContext(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	tenant := NewRegistry()
+	tenant.Register(&test2{}, "test")
+
+	ctx := context.Background()
+	if FromContext(ctx) != DefaultRegistry {
+		t.Fatal("expected the default registry for a context without one")
+	}
+	tctx := WithRegistry(ctx, tenant)
+	if FromContext(tctx) != tenant {
+		t.Fatal("expected the registry carried by the context")
+	}
+
+	for c, expected := range map[context.Context]reflect.Type{
+		ctx:  reflect.TypeOf(&test{}),
+		tctx: reflect.TypeOf(&test2{}),
+	} {
+		v, err := UnmarshalAnyContext(c, &anypb.Any{TypeUrl: "test", Value: []byte("{}")})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if reflect.TypeOf(v) != expected {
+			t.Fatalf("expected %s, got %T", expected, v)
+		}
+	}
+
+	any, err := MarshalAnyContext(tctx, &test2{Name: "koye"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if any.GetTypeUrl() != "test" {
+		t.Fatalf("unexpected url %q", any.GetTypeUrl())
+	}
+	if _, err := MarshalAnyContext(ctx, &test2{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound from the default registry, got %v", err)
+	}
+}
+
+func TestMarshalAnyMasked(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	field := &descriptorpb.FieldDescriptorProto{
+		Name:     proto.String("name"),
+		Number:   proto.Int32(1),
+		JsonName: proto.String("name"),
+		Options: &descriptorpb.FieldOptions{
+			Deprecated: proto.Bool(true),
+			Packed:     proto.Bool(true),
+		},
+	}
+	any, err := MarshalAnyMasked(field, &fieldmaskpb.FieldMask{Paths: []string{"name", "options.deprecated"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := UnmarshalAny(any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	masked := v.(*descriptorpb.FieldDescriptorProto)
+	if masked.GetName() != "name" || !masked.GetOptions().GetDeprecated() {
+		t.Fatalf("expected masked fields to be kept, got %v", masked)
+	}
+	if masked.Number != nil || masked.JsonName != nil || masked.GetOptions().Packed != nil {
+		t.Fatalf("expected unmasked fields to be cleared, got %v", masked)
+	}
+	if field.Number == nil || field.GetOptions().Packed == nil {
+		t.Fatal("the marshaled message should not be modified")
+	}
+
+	if _, err := MarshalAnyMasked(field, &fieldmaskpb.FieldMask{Paths: []string{"unknown"}}); err == nil {
+		t.Fatal("expected an error for an invalid field mask")
+	}
+	if _, err := MarshalAnyMasked(&test{Name: "koye"}, &fieldmaskpb.FieldMask{Paths: []string{"Name"}}); err == nil {
+		t.Fatal("expected an error for a value which is not a protocol buffer message")
+	}
+}
+
+func TestUnmarshalJSONAny(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	v, err := UnmarshalJSONAny([]byte(`{"@type":"type.googleapis.com/google.protobuf.FieldDescriptorProto","typeName":"Foo"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fd, ok := v.(*descriptorpb.FieldDescriptorProto); !ok || fd.GetTypeName() != "Foo" {
+		t.Fatalf("expected a field descriptor with type name Foo, got %#v", v)
+	}
+
+	v, err = UnmarshalJSONAny([]byte(`{"@type":"type.googleapis.com/google.protobuf.Timestamp","value":"1970-01-01T00:00:01Z"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ts, ok := v.(*timestamppb.Timestamp); !ok || ts.GetSeconds() != 1 {
+		t.Fatalf("expected a timestamp of 1s, got %#v", v)
+	}
+
+	v, err = UnmarshalJSONAny([]byte(`{"@type":"test","Name":"koye","Age":6}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v, &test{Name: "koye", Age: 6}) {
+		t.Fatalf("expected the registered type, got %#v", v)
+	}
+
+	if _, err := UnmarshalJSONAny([]byte(`{"Name":"koye"}`)); err == nil || !strings.Contains(err.Error(), "@type") {
+		t.Fatalf("expected an error naming the missing @type field, got %v", err)
+	}
+	if _, err := UnmarshalJSONAny([]byte(`{"@type":"unknown"}`)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestMarshalProtoRuntimes(t *testing.T) {
+	clear()
+	// google.golang.org/protobuf messages satisfy both interfaces.
+	var _ proto.Message = &timestamppb.Timestamp{}
+
+	for _, v := range []interface{}{
+		&timestamppb.Timestamp{Seconds: 1, Nanos: 2},
+		&gogotypes.Timestamp{Seconds: 1, Nanos: 2},
+	} {
+		any, err := MarshalAny(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if any.GetTypeUrl() != "google.protobuf.Timestamp" {
+			t.Fatalf("unexpected url %q for %T", any.GetTypeUrl(), v)
+		}
+		for _, out := range []interface{}{&timestamppb.Timestamp{}, &gogotypes.Timestamp{}} {
+			if err := UnmarshalTo(any, out); err != nil {
+				t.Fatalf("%T into %T: %v", v, out, err)
+			}
+			if fmt.Sprint(reflect.ValueOf(out).Elem().FieldByName("Seconds")) != "1" ||
+				fmt.Sprint(reflect.ValueOf(out).Elem().FieldByName("Nanos")) != "2" {
+				t.Fatalf("%T into %T: unexpected value %v", v, out, out)
+			}
+		}
+	}
+}
+
+func TestMarshalAnyWithSchema(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	v := &test{Name: "koye", Age: 6}
+	any, err := MarshalAnyWithSchema(v, "3f2a9c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if any.GetTypeUrl() != "test?schema=3f2a9c" {
+		t.Fatalf("expected the schema id in the url, got %q", any.GetTypeUrl())
+	}
+	if id := SchemaID(any); id != "3f2a9c" {
+		t.Fatalf("expected schema id %q, got %q", "3f2a9c", id)
+	}
+	nv, err := UnmarshalAny(any)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(nv, v) {
+		t.Fatalf("round trip failed %v != %v", nv, v)
+	}
+
+	checksummed, err := MarshalAnyChecksummed(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := SchemaID(checksummed); id != "" {
+		t.Fatalf("expected no schema id, got %q", id)
+	}
+	if _, err := MarshalAnyWithSchema(v, ""); err == nil {
+		t.Fatal("expected an error for an empty schema id")
+	}
+}
+
+func TestMarshalAnyPBGogo(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	v := &test{Name: "koye", Age: 6}
+	pb, err := MarshalAnyPB(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gogo, err := MarshalAnyGogo(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pb.TypeUrl != "test" || gogo.TypeUrl != "test" || !bytes.Equal(pb.Value, gogo.Value) {
+		t.Fatalf("expected the same any, got %v and %v", pb, gogo)
+	}
+	nv, err := UnmarshalAny(pb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(nv, v) {
+		t.Fatalf("round trip failed %v != %v", nv, v)
+	}
+
+	if _, err := MarshalAnyPB(&test2{}); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+	if pb, err := MarshalAnyPB(nil); err != nil || pb != nil {
+		t.Fatalf("expected nil, got %v: %v", pb, err)
+	}
+}
+
+func TestCached(t *testing.T) {
+	clear()
+	Register(&test{}, "test")
+
+	v := &test{Name: "koye", Age: 6}
+	any := MustMarshalAny(v)
+	cached := Cached(any, v)
+	if cached.GetTypeUrl() != any.GetTypeUrl() || !bytes.Equal(cached.GetValue(), any.GetValue()) {
+		t.Fatalf("expected the type url and value of the any, got %v", cached)
+	}
+	nv, err := UnmarshalAny(cached)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nv != v {
+		t.Fatalf("expected the cached value, got %v", nv)
+	}
+
+	// a decoded value of another type is ignored.
+	nv, err = UnmarshalAny(Cached(any, &test2{}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(nv, v) || nv == v {
+		t.Fatalf("expected a newly decoded value, got %v", nv)
+	}
+	if Cached(nil, v) != nil {
+		t.Fatal("expected nil for a nil any")
+	}
+
+	garbage := Cached(New("test", []byte("{garbage")), &test{})
+	if _, err := UnmarshalAny(garbage); err != nil {
+		t.Fatalf("expected the cached value, got %v", err)
+	}
+	if err := Validate(garbage); err == nil {
+		t.Fatal("expected Validate to decode the value")
+	}
+
+	checksummed, err := MarshalAnyChecksummed(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt := ValueCopy(checksummed)
+	corrupt[0] ^= 0x01
+	if _, err := UnmarshalAny(Cached(New(checksummed.GetTypeUrl(), corrupt), v)); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected ErrChecksumMismatch, got %v", err)
+	}
+
+	r := NewRegistry()
+	r.Register(&test{}, "test")
+	r.MaxDecodedSize = 4
+	if _, err := r.UnmarshalAny(Cached(any, v)); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestMarshalDoublePointer(t *testing.T) {
+	clear()
+	v := &test{Name: "koye", Age: 6}
+	pv := &v
+	Register(&pv, "test")
+	if typ, ok := TypeOf("test"); !ok || typ != reflect.TypeOf(test{}) {
+		t.Fatalf("expected **test to register test, got %v", typ)
+	}
+	Register(v, "test")
+
+	for _, in := range []interface{}{v, pv, &pv} {
+		url, err := TypeURL(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if url != "test" {
+			t.Fatalf("expected url %q for %T, got %q", "test", in, url)
+		}
+		any, err := MarshalAny(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nv, err := UnmarshalAny(any)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(nv, v) {
+			t.Fatalf("round trip of %T failed %v != %v", in, nv, v)
+		}
+	}
+
+	ts := timestamppb.Now()
+	pts := &ts
+	any, err := MarshalAny(&pts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := MustMarshalAny(ts)
+	if any.GetTypeUrl() != expected.GetTypeUrl() || !bytes.Equal(any.GetValue(), expected.GetValue()) {
+		t.Fatalf("expected a pointer to a message to marshal as the message, got %v", any)
+	}
+
+	var nilTest *test
+	any, err = MarshalAny(&nilTest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if any.GetTypeUrl() != "test" || len(any.GetValue()) != 0 {
+		t.Fatalf("expected an empty value of type test, got %v", any)
+	}
+
+	if url, err := TypeURL(&pts); err != nil || url != "google.protobuf.Timestamp" {
+		t.Fatalf("expected the url of the message for a **Timestamp, got %q: %v", url, err)
+	}
+	if !Is(expected, &pts) {
+		t.Fatal("Is should match a **Timestamp")
+	}
+	if url, err := ElementTypeURL([]**timestamppb.Timestamp{}); err != nil || url != "google.protobuf.Timestamp" {
+		t.Fatalf("expected the url of the message for a []**Timestamp, got %q: %v", url, err)
+	}
+
+	size, err := MarshalSize(&pts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size != len(expected.GetValue()) {
+		t.Fatalf("expected a size of %d, got %d", len(expected.GetValue()), size)
+	}
+	if size, err := MarshalSize(&nilTest); err != nil || size != 0 {
+		t.Fatalf("expected a size of 0 for a nil *test, got %d: %v", size, err)
+	}
+	p := &point{X: 1, Y: 2}
+	if size, err := MarshalSize(&p); err != nil || size != 2 {
+		t.Fatalf("expected the size of the AnyMarshaler value, got %d: %v", size, err)
+	}
+}
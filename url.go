@@ -0,0 +1,98 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	neturl "net/url"
+	"strings"
+)
+
+// The type url of an Any value may record how the value was encoded, as
+// suffixes on the url the type was registered with, followed by parameters
+// describing the value in a query string:
+//
+//	<url>[+<codec>][+gzip][?<parameters>]
+//
+// The "v" parameter, set on the urls of types registered with
+// RegisterVersioned, is part of the identity of the type rather than of the
+// encoding.
+//
+// The codec suffix is omitted for JSONCodec and protocol buffer messages,
+// except for messages encoded as JSON by MarshalAnyJSON, which carry "+json".
+// Registered urls must therefore not contain a "?" or end in a suffix naming
+// a codec or compression.
+
+const (
+	gzipSuffix   = "+gzip"
+	jsonSuffix   = "+json"
+	versionParam = "v"
+)
+
+// encodedURL is a type url split into the url its type is registered with and
+// the encoding recorded for the value.
+type encodedURL struct {
+	url        string
+	codec      Codec
+	compressed bool
+	// json is set when the url carries an explicit json suffix, which
+	// protocol buffer messages encoded as JSON require.
+	json   bool
+	params neturl.Values
+}
+
+func parseTypeURL(typeURL string) encodedURL {
+	var e encodedURL
+	if i := strings.IndexByte(typeURL, '?'); i >= 0 {
+		if params, err := neturl.ParseQuery(typeURL[i+1:]); err == nil {
+			e.params = params
+		}
+		typeURL = typeURL[:i]
+	}
+	if strings.HasSuffix(typeURL, gzipSuffix) {
+		typeURL = strings.TrimSuffix(typeURL, gzipSuffix)
+		e.compressed = true
+	}
+	e.json = strings.HasSuffix(typeURL, jsonSuffix)
+	e.url, e.codec = splitCodec(typeURL)
+	if v := e.params.Get(versionParam); v != "" {
+		e.url += "?" + versionParam + "=" + v
+	}
+	return e
+}
+
+// withSuffix returns the type url with suffix appended to its url, before any
+// parameters.
+func withSuffix(typeURL, suffix string) string {
+	if i := strings.IndexByte(typeURL, '?'); i >= 0 {
+		return typeURL[:i] + suffix + typeURL[i:]
+	}
+	return typeURL + suffix
+}
+
+// withParam returns the type url with the parameter key set to value.
+func withParam(typeURL, key, value string) string {
+	base, query := typeURL, ""
+	if i := strings.IndexByte(typeURL, '?'); i >= 0 {
+		base, query = typeURL[:i], typeURL[i+1:]
+	}
+	params, err := neturl.ParseQuery(query)
+	if err != nil {
+		params = neturl.Values{}
+	}
+	params.Set(key, value)
+	return base + "?" + params.Encode()
+}
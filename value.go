@@ -0,0 +1,221 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	gogoproto "github.com/gogo/protobuf/proto"
+	"google.golang.org/protobuf/proto"
+)
+
+// MergeAny merges the protocol buffer message of patch into that of base,
+// using the merge semantics of protocol buffers, and returns the result
+// marshaled as MarshalAny would. Both must hold the same message type. Other
+// types are rejected, as they have no defined merge semantics. Neither base
+// nor patch is modified.
+func MergeAny(base, patch Any) (Any, error) {
+	return DefaultRegistry.MergeAny(base, patch)
+}
+
+// MergeAny merges patch into base, resolving their type urls with the
+// registry. See MergeAny.
+func (r *Registry) MergeAny(base, patch Any) (Any, error) {
+	if isNil(base) || isNil(patch) {
+		return nil, fmt.Errorf("cannot merge a nil any")
+	}
+	if !r.SameType(base, patch) {
+		return nil, fmt.Errorf("cannot merge type %q into type %q", patch.GetTypeUrl(), base.GetTypeUrl())
+	}
+	// decode base afresh, as the value of a Cached Any must not be modified.
+	b, err := r.UnmarshalByTypeURL(base.GetTypeUrl(), base.GetValue())
+	if err != nil {
+		return nil, err
+	}
+	p, err := r.UnmarshalAny(patch)
+	if err != nil {
+		return nil, err
+	}
+	switch t := b.(type) {
+	case proto.Message:
+		proto.Merge(t, p.(proto.Message))
+	case gogoproto.Message:
+		gogoproto.Merge(t, p.(gogoproto.Message))
+	default:
+		return nil, fmt.Errorf("cannot merge type %q: not a protocol buffer message", base.GetTypeUrl())
+	}
+	return r.MarshalAny(b)
+}
+
+// ValueEqual reports whether a and b hold equal values once decoded, unlike
+// Equal, which compares their encodings. Protocol buffer messages are
+// compared with proto.Equal and other types with reflect.DeepEqual. Values of
+// different types are never equal, while two nil Anys are. An error is
+// returned if either value cannot be unmarshaled.
+func ValueEqual(a, b Any) (bool, error) {
+	return DefaultRegistry.ValueEqual(a, b)
+}
+
+// ValueEqual reports whether a and b hold equal values, resolving their type
+// urls with the registry. See ValueEqual.
+func (r *Registry) ValueEqual(a, b Any) (bool, error) {
+	if isNil(a) || isNil(b) {
+		return isNil(a) && isNil(b), nil
+	}
+	if !r.SameType(a, b) {
+		return false, nil
+	}
+	va, err := r.UnmarshalAny(a)
+	if err != nil {
+		return false, err
+	}
+	vb, err := r.UnmarshalAny(b)
+	if err != nil {
+		return false, err
+	}
+	switch t := va.(type) {
+	case proto.Message:
+		return proto.Equal(t, vb.(proto.Message)), nil
+	case gogoproto.Message:
+		return gogoproto.Equal(t, vb.(gogoproto.Message)), nil
+	default:
+		return reflect.DeepEqual(va, vb), nil
+	}
+}
+
+// RoundTrip marshals v with MarshalAny and unmarshals the result into a new
+// value of the same type, which it returns. It is intended for tests of
+// packages registering their own types, asserting that the result equals v.
+// As with MarshalAny, v must be a pointer.
+func RoundTrip(v interface{}) (interface{}, error) {
+	return DefaultRegistry.RoundTrip(v)
+}
+
+// RoundTrip marshals and unmarshals v using the registry. See RoundTrip.
+func (r *Registry) RoundTrip(v interface{}) (interface{}, error) {
+	any, err := r.MarshalAny(v)
+	if err != nil {
+		return nil, err
+	}
+	out := reflect.New(tryDereference(v)).Interface()
+	if err := r.UnmarshalTo(any, out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// Diff describes the differences between the decoded values of a and b, one
+// line per differing field, such as `Name: "koye" != "mikan"`. Fields are
+// compared in their JSON form, using the JSON mapping of protocol buffer
+// messages. An empty string is returned when the values are equal. When the
+// type urls of a and b do not resolve to the same type, Diff reports
+// "different type URLs" without decoding the values, as it does when only one
+// of a and b is nil.
+func Diff(a, b Any) (string, error) {
+	return DefaultRegistry.Diff(a, b)
+}
+
+// Diff describes the differences between the decoded values of a and b,
+// resolving their type urls with the registry. See Diff.
+func (r *Registry) Diff(a, b Any) (string, error) {
+	switch {
+	case isNil(a) && isNil(b):
+		return "", nil
+	case isNil(a):
+		return fmt.Sprintf("different type URLs: <nil> != %q", b.GetTypeUrl()), nil
+	case isNil(b):
+		return fmt.Sprintf("different type URLs: %q != <nil>", a.GetTypeUrl()), nil
+	}
+	if !r.SameType(a, b) {
+		return fmt.Sprintf("different type URLs: %q != %q", a.GetTypeUrl(), b.GetTypeUrl()), nil
+	}
+	va, err := r.jsonValue(a)
+	if err != nil {
+		return "", err
+	}
+	vb, err := r.jsonValue(b)
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	diffJSON("", va, vb, &lines)
+	return strings.Join(lines, "\n"), nil
+}
+
+// jsonValue decodes any into the generic form of its JSON encoding.
+func (r *Registry) jsonValue(any Any) (interface{}, error) {
+	data, err := r.indentJSON(any)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	return v, json.Unmarshal(data, &v)
+}
+
+func diffJSON(path string, a, b interface{}, lines *[]string) {
+	switch ta := a.(type) {
+	case map[string]interface{}:
+		if tb, ok := b.(map[string]interface{}); ok {
+			keys := make(map[string]struct{}, len(ta)+len(tb))
+			for k := range ta {
+				keys[k] = struct{}{}
+			}
+			for k := range tb {
+				keys[k] = struct{}{}
+			}
+			sorted := make([]string, 0, len(keys))
+			for k := range keys {
+				sorted = append(sorted, k)
+			}
+			sort.Strings(sorted)
+			for _, k := range sorted {
+				p := k
+				if path != "" {
+					p = path + "." + k
+				}
+				diffJSON(p, ta[k], tb[k], lines)
+			}
+			return
+		}
+	case []interface{}:
+		if tb, ok := b.([]interface{}); ok && len(ta) == len(tb) {
+			for i := range ta {
+				diffJSON(fmt.Sprintf("%s[%d]", path, i), ta[i], tb[i], lines)
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(a, b) {
+		if path == "" {
+			path = "value"
+		}
+		*lines = append(*lines, fmt.Sprintf("%s: %s != %s", path, jsonString(a), jsonString(b)))
+	}
+}
+
+// jsonString renders a generic JSON value. Missing values render as null.
+func jsonString(v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
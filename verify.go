@@ -0,0 +1,88 @@
+/*
+   Copyright The containerd Authors.
+
+   Licensed under the Apache License, Version 2.0 (the "License");
+   you may not use this file except in compliance with the License.
+   You may obtain a copy of the License at
+
+       http://www.apache.org/licenses/LICENSE-2.0
+
+   Unless required by applicable law or agreed to in writing, software
+   distributed under the License is distributed on an "AS IS" BASIS,
+   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+   See the License for the specific language governing permissions and
+   limitations under the License.
+*/
+
+package typeurl
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// VerifyRegistry checks the registered urls for registrations likely to cause
+// conflicts, returning an error for each url which:
+//
+//   - is bare, without a "/" qualifying it with a domain or path or a "."
+//     qualifying it with a package, as full message names registered with
+//     RegisterByName are,
+//   - shares its name, the part after the last "/", with a url registered for
+//     another type, so that the urls resolve to the first registered type
+//     when NormalizeURLs is set, or
+//   - is rejected by the function set with SetURLValidator, such as a url
+//     registered before the validator was set.
+//
+// The errors are sorted by url. It is intended to run as a test, so that such
+// registrations fail CI rather than being found in review.
+func VerifyRegistry() []error {
+	return DefaultRegistry.VerifyRegistry()
+}
+
+// VerifyRegistry checks the urls registered with the registry. See
+// VerifyRegistry.
+func (r *Registry) VerifyRegistry() []error {
+	r.mu.Lock()
+	validator := r.validator
+	r.mu.Unlock()
+	s := r.load()
+
+	urls := make([]string, 0, len(s.byURL))
+	names := make(map[string][]reflect.Type)
+	for u, t := range s.byURL {
+		urls = append(urls, u)
+		n := urlName(u)
+		if !containsType(names[n], t) {
+			names[n] = append(names[n], t)
+		}
+	}
+	sort.Strings(urls)
+
+	var errs []error
+	for _, u := range urls {
+		t := s.byURL[u]
+		if !strings.ContainsAny(u, "/.") {
+			errs = append(errs, fmt.Errorf("url %q of type %s is not qualified with a domain, path or package", u, t))
+		}
+		if types := names[urlName(u)]; len(types) > 1 {
+			errs = append(errs, fmt.Errorf("url %q of type %s shares its name with the urls of %d other types", u, t, len(types)-1))
+		}
+		if validator != nil {
+			if err := validator(u); err != nil {
+				errs = append(errs, fmt.Errorf("invalid url %q for type %s: %w", u, t, err))
+			}
+		}
+	}
+	return errs
+}
+
+func containsType(types []reflect.Type, t reflect.Type) bool {
+	for _, other := range types {
+		if other == t {
+			return true
+		}
+	}
+	return false
+}